@@ -0,0 +1,124 @@
+package bitcask_go
+
+import (
+	"sync"
+	"time"
+)
+
+// AutoMergeStat 后台自动 merge 的状态
+type AutoMergeStat struct {
+	Runs           uint      // 成功执行的次数
+	LastRun        time.Time // 最近一次执行的时间，包括执行失败
+	LastReclaimed  int64     // 最近一次成功执行回收的数据量，字节为单位
+	TotalReclaimed int64     // 累计回收的数据量，字节为单位
+	LastError      error     // 最近一次执行的错误，执行成功之后会被清空
+}
+
+// 后台自动 merge
+type autoMerger struct {
+	db       *DB
+	mu       *sync.Mutex
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+	stopOnce *sync.Once
+	stat     AutoMergeStat
+}
+
+// 启动后台自动 merge，只读模式或者没有配置检查间隔时不启动
+func (db *DB) startAutoMerge() {
+	if db.options.ReadOnly || db.options.AutoMergeInterval <= 0 {
+		return
+	}
+	am := &autoMerger{
+		db:       db,
+		mu:       new(sync.Mutex),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+		stopOnce: new(sync.Once),
+	}
+	db.autoMerger = am
+	go am.run()
+}
+
+func (am *autoMerger) run() {
+	defer close(am.doneCh)
+	ticker := time.NewTicker(am.db.options.AutoMergeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-am.stopCh:
+			return
+		case now := <-ticker.C:
+			am.tryMerge(now)
+		}
+	}
+}
+
+// 满足时间窗口和最小回收量时执行 merge
+func (am *autoMerger) tryMerge(now time.Time) {
+	db := am.db
+	if !inMergeWindows(db.options.AutoMergeWindows, now) {
+		return
+	}
+
+	db.mu.RLock()
+	reclaimable := db.reclaimSize
+	db.mu.RUnlock()
+	if reclaimable <= 0 || reclaimable < db.options.AutoMergeMinReclaimSize {
+		return
+	}
+
+	reclaimed, err := db.merge()
+	// 没有达到 merge 的阈值，或者有其他的 merge 正在进行，等待下一次检查
+	if err == ErrMergeRatioUnreached || err == ErrMergeIsProgress {
+		return
+	}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.stat.LastRun = now
+	am.stat.LastError = err
+	if err != nil {
+		db.events.post(func(l EventListener) {
+			l.OnBackgroundError(err)
+		})
+		return
+	}
+	am.stat.Runs++
+	am.stat.LastReclaimed = reclaimed
+	am.stat.TotalReclaimed += reclaimed
+}
+
+// 停止后台自动 merge，等待正在执行的 merge 完成
+func (am *autoMerger) stop() {
+	am.stopOnce.Do(func() {
+		close(am.stopCh)
+	})
+	<-am.doneCh
+}
+
+func (am *autoMerger) getStat() AutoMergeStat {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	return am.stat
+}
+
+// 判断当前时间是否在允许 merge 的时间窗口内，没有配置时间窗口表示任何时间都可以
+func inMergeWindows(windows []MergeWindow, now time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	year, month, day := now.Date()
+	offset := now.Sub(time.Date(year, month, day, 0, 0, 0, 0, now.Location()))
+	for _, window := range windows {
+		if window.Start <= window.End {
+			if offset >= window.Start && offset < window.End {
+				return true
+			}
+		} else if offset >= window.Start || offset < window.End {
+			// 跨越零点的时间窗口
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0.3
+	opts.AutoMergeInterval = 10 * time.Millisecond
+	opts.AutoMergeMinReclaimSize = 1024
+	listener := &recordingListener{}
+	opts.EventListener = listener
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	// 无效数据占比没有达到阈值，不会执行 merge
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint(0), db.Stat().AutoMerge.Runs)
+
+	for i := 0; i < 800; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return db.Stat().AutoMerge.Runs > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	stat := db.Stat()
+	assert.Nil(t, stat.AutoMerge.LastError)
+	assert.False(t, stat.AutoMerge.LastRun.IsZero())
+	assert.Greater(t, stat.AutoMerge.LastReclaimed, int64(0))
+	// merge 的结果在线生效，无效数据已经被回收
+	assert.Less(t, stat.ReclaimableSize, stat.AutoMerge.TotalReclaimed)
+	assert.Equal(t, 200, len(db.ListKeys()))
+
+	// 已经回收的无效数据不会重复 merge
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stat.AutoMerge.Runs, db.Stat().AutoMerge.Runs)
+
+	// 关闭时停止后台 merge，重启之后数据不变
+	err = db.Close()
+	assert.Nil(t, err)
+	// 回收的数据量是 merge 实际回收的数据量
+	var reclaimed int64
+	for _, info := range listener.mergeEnd {
+		reclaimed += info.ReclaimedBytes
+	}
+	assert.Equal(t, reclaimed, stat.AutoMerge.TotalReclaimed)
+	assert.Equal(t, listener.mergeEnd[len(listener.mergeEnd)-1].ReclaimedBytes, stat.AutoMerge.LastReclaimed)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, len(db2.ListKeys()))
+	assert.Equal(t, stat.ReclaimableSize, db2.Stat().ReclaimableSize)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestInMergeWindows(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2023, 6, 1, hour, 30, 0, 0, time.Local)
+	}
+	assert.True(t, inMergeWindows(nil, at(12)))
+
+	windows := []MergeWindow{{Start: 2 * time.Hour, End: 4 * time.Hour}}
+	assert.True(t, inMergeWindows(windows, at(3)))
+	assert.False(t, inMergeWindows(windows, at(4)))
+
+	// 跨越零点的时间窗口
+	windows = []MergeWindow{{Start: 22 * time.Hour, End: 6 * time.Hour}}
+	assert.True(t, inMergeWindows(windows, at(23)))
+	assert.True(t, inMergeWindows(windows, at(1)))
+	assert.False(t, inMergeWindows(windows, at(12)))
+}
@@ -2,9 +2,11 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/index"
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const nonTransactionSeqNo uint64 = 0
@@ -19,9 +21,9 @@ type WriteBatch struct {
 	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据
 }
 
-// NewWriteBatch 初始化 WriteBatch
+// NewWriteBatch 初始化 WriteBatch，只读模式下 WriteBatch 的写入和提交都会返回 ErrDatabaseIsReadOnly
 func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
-	if db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isInitial {
+	if !db.options.ReadOnly && db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isInitial {
 		panic("cannot use write batch, seq no file not exists")
 	}
 	return &WriteBatch{
@@ -34,43 +36,82 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 
 // Put 批量写数据
 func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	return wb.put(defaultNamespaceId, key, value)
+}
+
+// Delete 删除数据
+func (wb *WriteBatch) Delete(key []byte) error {
+	return wb.delete(defaultNamespaceId, wb.db.index, key)
+}
+
+// PutIn 批量写数据到指定的 namespace，同一个批次中不同 namespace 的数据原子提交
+func (wb *WriteBatch) PutIn(ns *Namespace, key []byte, value []byte) error {
+	return wb.put(ns.id, key, value)
+}
+
+// DeleteIn 删除指定 namespace 中的数据
+func (wb *WriteBatch) DeleteIn(ns *Namespace, key []byte) error {
+	return wb.delete(ns.id, ns.index, key)
+}
+
+func (wb *WriteBatch) put(nsId uint32, key []byte, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if wb.db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
 	// 暂存 LogRecord
-	logRecord := &data.LogRecord{Key: key, Value: value}
-	wb.pendingWrites[string(key)] = logRecord
+	logRecord := &data.LogRecord{Key: key, Value: value, Namespace: nsId}
+	wb.pendingWrites[pendingWriteKey(nsId, key)] = logRecord
 	return nil
 }
 
-// Delete 删除数据
-func (wb *WriteBatch) Delete(key []byte) error {
+func (wb *WriteBatch) delete(nsId uint32, idx index.Indexer, key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if wb.db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
 	// 数据不存在则直接返回
-	logRecordPos := wb.db.index.Get(key)
+	pendingKey := pendingWriteKey(nsId, key)
+	logRecordPos := idx.Get(key)
 	if logRecordPos == nil {
-		if wb.pendingWrites[string(key)] != nil {
-			delete(wb.pendingWrites, string(key))
+		if wb.pendingWrites[pendingKey] != nil {
+			delete(wb.pendingWrites, pendingKey)
 		}
 		return nil
 	}
 
 	// 暂存 LogRecord
-	logRecord := &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
-	wb.pendingWrites[string(key)] = logRecord
+	logRecord := &data.LogRecord{Key: key, Type: data.LogRecordDeleted, Namespace: nsId}
+	wb.pendingWrites[pendingKey] = logRecord
 	return nil
 }
 
+// 暂存数据的 key，不同 namespace 中相同的 key 互不影响
+func pendingWriteKey(nsId uint32, key []byte) string {
+	if nsId == defaultNamespaceId {
+		return string(key)
+	}
+	buf := make([]byte, 4+len(key))
+	binary.BigEndian.PutUint32(buf[:4], nsId)
+	copy(buf[4:], key)
+	return string(buf)
+}
+
 // Commit 提交事务，将暂存的数据写到数据文件，并更新内存索引
 func (wb *WriteBatch) Commit() error {
+	if wb.db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
@@ -81,25 +122,61 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchNum
 	}
 
+	defer wb.db.metrics.observe(wb.db.metrics.commits, wb.db.metrics.commitLatency, time.Now())
+	// 压缩和加密不需要持有互斥锁
+	encoded, err := wb.db.encodePendingWrites(wb.pendingWrites)
+	if err != nil {
+		return err
+	}
 	// 加锁保证事务提交串行化
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	if err := wb.db.commit(wb.options.SyncWrites, func() error {
+		return wb.db.commitPendingWrites(wb.pendingWrites, encoded, wb.options.SyncWrites)
+	}); err != nil {
+		return err
+	}
+
+	// 清空暂存数据
+	wb.pendingWrites = make(map[string]*data.LogRecord)
 
+	return nil
+}
+
+// 在持有互斥锁之前压缩和加密暂存的数据
+func (db *DB) encodePendingWrites(pendingWrites map[string]*data.LogRecord) (map[*data.LogRecord]*encodedValue, error) {
+	encoded := make(map[*data.LogRecord]*encodedValue, len(pendingWrites))
+	for _, record := range pendingWrites {
+		if record.Type != data.LogRecordNormal {
+			continue
+		}
+		value, err := db.encodeValue(record.Value)
+		if err != nil {
+			return nil, err
+		}
+		encoded[record] = value
+	}
+	return encoded, nil
+}
+
+// 将暂存的数据以事务的方式写到数据文件，并更新内存索引，encoded 是提前压缩和加密过的 value
+// 在访问此方法前必须持有互斥锁
+func (db *DB) commitPendingWrites(pendingWrites map[string]*data.LogRecord, encoded map[*data.LogRecord]*encodedValue, syncWrites bool) error {
 	// 获取当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 
 	// 开始写数据到数据文件当中
-	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
-		})
+	positions := make(map[*data.LogRecord]*data.LogRecordPos)
+	for _, record := range pendingWrites {
+		logRecordPos, err := db.appendEncodedRecord(&data.LogRecord{
+			Key:       logRecordKeyWithSeq(record.Key, seqNo),
+			Value:     record.Value,
+			Type:      record.Type,
+			Expire:    record.Expire,
+			Namespace: record.Namespace,
+		}, encoded[record], false)
 		if err != nil {
 			return err
 		}
-		positions[string(record.Key)] = logRecordPos
+		positions[record] = logRecordPos
 	}
 
 	// 写一条标识事务完成的数据
@@ -107,35 +184,40 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished,
 	}
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
+	if _, err := db.appendLogRecord(finishedRecord, false); err != nil {
 		return err
 	}
 
 	// 根据配置决定是否持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+	if syncWrites && db.activeFile != nil {
+		if err := db.syncWrites(); err != nil {
 			return err
 		}
 	}
 
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
+	// 更新内存索引，变更订阅只针对默认的 namespace
+	for _, record := range pendingWrites {
+		pos := positions[record]
+		idx := db.indexOf(record.Namespace)
+		isDefault := record.Namespace == defaultNamespaceId
+		db.saveTxnSnapshot(record.Namespace, record.Key)
 		var oldPos *data.LogRecordPos
 		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+			oldPos = idx.Put(record.Key, pos)
+			if isDefault {
+				db.addWatchEvent(WatchEventPut, record.Key, record.Value, seqNo)
+			}
 		}
 		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			oldPos, _ = idx.Delete(record.Key)
+			if isDefault {
+				db.addWatchEvent(WatchEventDelete, record.Key, nil, seqNo)
+			}
 		}
 		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+			db.addNamespaceReclaimSize(record.Namespace, oldPos)
 		}
 	}
-
-	// 清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-
 	return nil
 }
 
@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"math/rand"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -62,3 +63,53 @@ func Benchmark_Delete(b *testing.B) {
 		assert.Nil(b, err)
 	}
 }
+
+// 开启 SyncWrites 的数据库，每次写入都需要持久化
+func openSyncDB(b *testing.B) *bitcask.DB {
+	options := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bench-sync")
+	options.DirPath = dir
+	options.SyncWrites = true
+	syncDB, err := bitcask.Open(options)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_ = syncDB.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return syncDB
+}
+
+func Benchmark_PutSync(b *testing.B) {
+	syncDB := openSyncDB(b)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		err := syncDB.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(b, err)
+	}
+}
+
+// 并发的写入通过组提交合并持久化，与 Benchmark_PutSync 对比每次写入的耗时
+func Benchmark_PutSyncParallel(b *testing.B) {
+	syncDB := openSyncDB(b)
+	// 生成随机 value 的随机数生成器不是并发安全的，提前生成并发写入使用的 value
+	values := make([][]byte, 1024)
+	for i := range values {
+		values[i] = utils.RandomValue(1024)
+	}
+	var counter int64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&counter, 1)
+			err := syncDB.Put(utils.GetTestKey(int(i)), values[int(i)%len(values)])
+			assert.Nil(b, err)
+		}
+	})
+}
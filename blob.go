@@ -0,0 +1,391 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CompactBlobs 回收 blob 文件中的无效数据
+// 无效数据占比达到 Options.BlobGCRatio 的 blob 文件，会将其中有效的 value 重写到活跃 blob 文件中，
+// 并在数据文件中追加新的 blob 位置，旧的 blob 文件在下一次启动时删除
+// 回收期间持有互斥锁，写入会被阻塞
+func (db *DB) CompactBlobs() error {
+	if db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 取出达到回收阈值的 blob 文件，活跃 blob 文件不参与回收
+	var gcFiles []*data.DataFile
+	for fid, file := range db.olderBlobFiles {
+		if _, ok := db.obsoleteBlobFiles[fid]; ok {
+			continue
+		}
+		if file.WriteOff == 0 {
+			continue
+		}
+		if float32(db.blobReclaimSize[fid])/float32(file.WriteOff) >= db.options.BlobGCRatio {
+			gcFiles = append(gcFiles, file)
+		}
+	}
+	if len(gcFiles) == 0 {
+		return ErrBlobGCRatioUnreached
+	}
+	sort.Slice(gcFiles, func(i, j int) bool {
+		return gcFiles[i].FileId < gcFiles[j].FileId
+	})
+
+	obsoleteFile, err := data.OpenBlobObsoleteFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer obsoleteFile.Close()
+	size, err := obsoleteFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	obsoleteFile.WriteOff = size
+
+	for _, blobFile := range gcFiles {
+		if err := db.rewriteBlobFile(blobFile); err != nil {
+			return err
+		}
+		// 重写的数据持久化之后，才能将旧的 blob 文件标记为已回收
+		if err := db.syncActiveFiles(); err != nil {
+			return err
+		}
+		record := &data.LogRecord{Key: []byte(strconv.Itoa(int(blobFile.FileId)))}
+		encRecord, _ := data.EncodeLogRecord(record)
+		if err := obsoleteFile.Write(encRecord); err != nil {
+			return err
+		}
+		if err := obsoleteFile.Sync(); err != nil {
+			return err
+		}
+		// 旧的 blob 文件在本次运行期间依然可以读取，进行中的事务和迭代器不受影响
+		db.obsoleteBlobFiles[blobFile.FileId] = struct{}{}
+		delete(db.blobReclaimSize, blobFile.FileId)
+	}
+	return nil
+}
+
+// 将 blob 文件中有效的 value 重写到活跃 blob 文件中
+// 在访问此方法前必须持有互斥锁
+func (db *DB) rewriteBlobFile(blobFile *data.DataFile) error {
+	now := time.Now().UnixNano()
+	var offset int64 = 0
+	for {
+		blobRecord, size, err := blobFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		blobOffset := offset
+		offset += size
+
+		// 和内存索引中的位置进行比较，判断 value 是否有效
+		idx := db.indexOf(blobRecord.Namespace)
+		if idx == nil {
+			continue
+		}
+		pos := idx.Get(blobRecord.Key)
+		if pos == nil || pos.BlobSize == 0 || pos.BlobFid != blobFile.FileId || pos.IsExpired(now) {
+			continue
+		}
+		logRecord, err := db.readLogRecordByPosition(pos, DefaultReadOptions)
+		if err != nil {
+			return err
+		}
+		if !logRecord.BlobRef || data.DecodeBlobPos(logRecord.Value).Offset != blobOffset {
+			continue
+		}
+
+		// 重写 value，并在数据文件中追加新的位置，保留原始的写入时间和过期时间
+		encoded, err := db.encodeValue(blobRecord.Value)
+		if err != nil {
+			return err
+		}
+		blobPos, err := db.appendBlobRecord(blobRecord.Key, blobRecord.Namespace, encoded, blobRecord.Timestamp)
+		if err != nil {
+			return err
+		}
+		newPos, err := db.appendRewrittenRecord(&data.LogRecord{
+			Key:       logRecordKeyWithSeq(blobRecord.Key, nonTransactionSeqNo),
+			Value:     data.EncodeBlobPos(blobPos),
+			Type:      data.LogRecordNormal,
+			Expire:    logRecord.Expire,
+			Timestamp: logRecord.Timestamp,
+			BlobRef:   true,
+			Namespace: blobRecord.Namespace,
+		}, db.options.SyncWrites)
+		if err != nil {
+			return err
+		}
+		// value 没有变化，不需要为进行中的事务保存快照
+		if oldPos := idx.Put(blobRecord.Key, newPos); oldPos != nil {
+			db.addNamespaceReclaimSize(blobRecord.Namespace, oldPos)
+		}
+	}
+	return nil
+}
+
+// 将 value 写入到活跃 blob 文件中
+// 在访问此方法前必须持有互斥锁
+func (db *DB) appendBlobRecord(key []byte, nsId uint32, encoded *encodedValue, timestamp int64) (*data.BlobPos, error) {
+	if db.activeBlobFile == nil {
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	// blob 文件中同样保存 key，用于回收时判断 value 是否有效，value 已经压缩和加密过
+	blobRecord := &data.LogRecord{
+		Key:       key,
+		Value:     encoded.value,
+		Codec:     encoded.codec,
+		KeyID:     encoded.keyID,
+		Timestamp: timestamp,
+		Namespace: nsId,
+	}
+	if err := blobRecord.Encrypt(db.options.Encryption, db.options.EncryptKeys); err != nil {
+		return nil, err
+	}
+	encRecord, size := data.EncodeLogRecord(blobRecord)
+
+	// 活跃 blob 文件达到阈值，打开新的 blob 文件
+	if db.activeBlobFile.WriteOff > 0 && db.activeBlobFile.WriteOff+size > db.options.BlobFileSize {
+		if err := db.syncDataFile(db.activeBlobFile, true); err != nil {
+			return nil, err
+		}
+		db.olderBlobFiles[db.activeBlobFile.FileId] = db.activeBlobFile
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	writeOff := db.activeBlobFile.WriteOff
+	if err := db.activeBlobFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	db.metrics.bytesWritten.Add(uint64(size))
+	return &data.BlobPos{
+		Fid:    db.activeBlobFile.FileId,
+		Offset: writeOff,
+		Size:   uint32(size),
+	}, nil
+}
+
+// 设置当前活跃 blob 文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) setActiveBlobFile() error {
+	var initialFileId uint32 = 0
+	if db.activeBlobFile != nil {
+		initialFileId = db.activeBlobFile.FileId + 1
+	}
+	blobFile, err := data.OpenBlobFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	blobFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
+	db.activeBlobFile = blobFile
+	return nil
+}
+
+// 根据 blob 位置读取 value
+func (db *DB) readBlobValue(blobPos *data.BlobPos, opts ReadOptions) ([]byte, error) {
+	var blobFile *data.DataFile
+	if db.activeBlobFile != nil && db.activeBlobFile.FileId == blobPos.Fid {
+		blobFile = db.activeBlobFile
+	} else {
+		blobFile = db.olderBlobFiles[blobPos.Fid]
+	}
+	if blobFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+
+	readLogRecord := blobFile.ReadLogRecord
+	if !opts.VerifyChecksum {
+		readLogRecord = blobFile.ReadLogRecordWithoutChecksum
+	}
+	blobRecord, size, err := readLogRecord(blobPos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	db.metrics.bytesRead.Add(uint64(size))
+	return blobRecord.Value, nil
+}
+
+// 持久化活跃 blob 文件和活跃数据文件
+// blob 文件需要先于数据文件持久化，保证数据文件中的 blob 位置都是有效的
+// 在访问此方法前必须持有互斥锁
+func (db *DB) syncActiveFiles() error {
+	if db.activeBlobFile != nil {
+		if err := db.syncDataFile(db.activeBlobFile, true); err != nil {
+			return err
+		}
+	}
+	return db.syncDataFile(db.activeFile, false)
+}
+
+// 持久化数据文件，记录持久化的次数和耗时，并通知监听者
+func (db *DB) syncDataFile(dataFile *data.DataFile, blob bool) error {
+	start := time.Now()
+	err := dataFile.Sync()
+	duration := time.Since(start)
+	db.metrics.syncs.Inc()
+	db.metrics.syncLatency.Observe(duration.Seconds())
+	info := SyncInfo{FileId: dataFile.FileId, Blob: blob, Duration: duration, Err: err}
+	db.events.post(func(l EventListener) {
+		l.OnSync(info)
+	})
+	return err
+}
+
+// 记录被覆盖或者删除的数据所占用的空间
+// 在访问此方法前必须持有互斥锁
+func (db *DB) addReclaimSize(pos *data.LogRecordPos) {
+	db.reclaimSize += int64(pos.Size)
+	db.fileReclaimSize[pos.Fid] += int64(pos.Size)
+	if pos.BlobSize > 0 {
+		db.blobReclaimSize[pos.BlobFid] += int64(pos.BlobSize)
+	}
+	// 合并操作数之前的记录和它一起失效
+	if node, ok := db.operands[operandPosOf(pos)]; ok && node.prev != nil {
+		db.addReclaimSize(node.prev)
+	}
+}
+
+// 所有 blob 文件的大小
+func (db *DB) blobFilesSize() int64 {
+	var size int64
+	if db.activeBlobFile != nil {
+		size += db.activeBlobFile.WriteOff
+	}
+	for _, file := range db.olderBlobFiles {
+		size += file.WriteOff
+	}
+	return size
+}
+
+// 从磁盘中加载 blob 文件，并删除已经回收的 blob 文件
+func (db *DB) loadBlobFiles() error {
+	if !db.options.ReadOnly {
+		if err := db.removeObsoleteBlobFiles(); err != nil {
+			return err
+		}
+	}
+
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.BlobFileNameSuffix) {
+			fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.BlobFileNameSuffix))
+			if err != nil {
+				return ErrDataDirectoryCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	sort.Ints(fileIds)
+
+	for i, fid := range fileIds {
+		blobFile, err := data.OpenBlobFile(db.options.DirPath, uint32(fid), fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		blobFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		blobFile.WriteOff = size
+		if i == len(fileIds)-1 {
+			db.activeBlobFile = blobFile
+		} else {
+			db.olderBlobFiles[uint32(fid)] = blobFile
+		}
+	}
+	return nil
+}
+
+// 删除上一次运行期间已经回收的 blob 文件
+func (db *DB) removeObsoleteBlobFiles() error {
+	fileName := filepath.Join(db.options.DirPath, data.BlobObsoleteFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	obsoleteFile, err := data.OpenBlobObsoleteFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	var offset int64 = 0
+	for {
+		record, size, err := obsoleteFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			_ = obsoleteFile.Close()
+			return err
+		}
+		offset += size
+
+		fileId, err := strconv.Atoi(string(record.Key))
+		if err != nil {
+			_ = obsoleteFile.Close()
+			return err
+		}
+		blobFileName := data.GetBlobFileName(db.options.DirPath, uint32(fileId))
+		if err := os.Remove(blobFileName); err != nil && !os.IsNotExist(err) {
+			_ = obsoleteFile.Close()
+			return err
+		}
+	}
+	if err := obsoleteFile.Close(); err != nil {
+		return err
+	}
+	return os.Remove(fileName)
+}
+
+// 根据内存索引统计每个 blob 文件中的无效数据量
+// 加载索引时累计的数据量会被覆盖
+func (db *DB) loadBlobReclaimSize() {
+	db.blobReclaimSize = make(map[uint32]int64)
+	if db.activeBlobFile == nil {
+		return
+	}
+	liveSize := make(map[uint32]int64)
+	indexes := []index.Indexer{db.index}
+	for _, ns := range db.namespaces {
+		indexes = append(indexes, ns.index)
+	}
+	for _, idx := range indexes {
+		iterator := idx.Iterator(false)
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			if pos := iterator.Value(); pos.BlobSize > 0 {
+				liveSize[pos.BlobFid] += int64(pos.BlobSize)
+			}
+		}
+		iterator.Close()
+	}
+
+	db.blobReclaimSize[db.activeBlobFile.FileId] = db.activeBlobFile.WriteOff - liveSize[db.activeBlobFile.FileId]
+	for fid, file := range db.olderBlobFiles {
+		db.blobReclaimSize[fid] = file.WriteOff - liveSize[fid]
+	}
+}
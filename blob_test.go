@@ -0,0 +1,116 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ValueSeparation(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 1024
+	opts.BlobFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	largeValue := bytes.Repeat([]byte("v"), 4096)
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), largeValue)
+		assert.Nil(t, err)
+	}
+	err = db.Put([]byte("small"), []byte("small-value"))
+	assert.Nil(t, err)
+
+	// 数据文件中只保存 blob 位置
+	assert.Less(t, db.activeFile.WriteOff, int64(100*len(largeValue)))
+	stat := db.Stat()
+	assert.Greater(t, stat.BlobFileNum, uint(1))
+	assert.Equal(t, int64(0), stat.BlobReclaimableSize)
+	val, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, largeValue, val)
+	val, err = db.Get([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("small-value"), val)
+
+	// merge 不会重写 blob 文件
+	blobSize := db.blobFilesSize()
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, blobSize, db.blobFilesSize())
+	val, err = db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, largeValue, val)
+
+	// 覆盖和删除数据之后，blob 文件中产生无效数据
+	newValue := bytes.Repeat([]byte("n"), 4096)
+	for i := 0; i < 50; i++ {
+		err := db.Put(utils.GetTestKey(i), newValue)
+		assert.Nil(t, err)
+	}
+	for i := 50; i < 60; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	stat = db.Stat()
+	assert.Greater(t, stat.BlobReclaimableSize, int64(60*len(largeValue)))
+
+	// 事务读取的是快照数据，回收不影响事务的读取
+	txn, err := db.Begin(true)
+	assert.Nil(t, err)
+
+	err = db.CompactBlobs()
+	assert.Nil(t, err)
+	assert.Less(t, db.Stat().BlobReclaimableSize, stat.BlobReclaimableSize)
+	err = db.CompactBlobs()
+	assert.Equal(t, ErrBlobGCRatioUnreached, err)
+	for _, i := range []int{1, 55, 70} {
+		val, err := txn.Get(utils.GetTestKey(i))
+		if i == 55 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+	txn.Rollback()
+
+	// 重启之后删除已经回收的 blob 文件
+	obsoleteFiles := len(db.obsoleteBlobFiles)
+	assert.Greater(t, obsoleteFiles, 0)
+	blobFileNum := db.Stat().BlobFileNum
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, blobFileNum-uint(obsoleteFiles), db.Stat().BlobFileNum)
+	_, err = os.Stat(data.GetBlobFileName(dir, 0))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 91, len(db.ListKeys()))
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		switch {
+		case i < 50:
+			assert.Nil(t, err)
+			assert.Equal(t, newValue, val)
+		case i < 60:
+			assert.Equal(t, ErrKeyNotFound, err)
+		default:
+			assert.Nil(t, err)
+			assert.Equal(t, largeValue, val)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package bitcask_go
+
+import "bytes"
+
+// PutIfAbsent 当 key 不存在（或者已经过期）时写入数据，返回是否写入成功
+// 写入时 key 不存在，没有需要保留的过期时间，写入的数据永不过期，已经过期的 key 原来的过期时间不会保留
+func (db *DB) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return false, ErrDatabaseIsReadOnly
+	}
+
+	encoded, err := db.encodeValue(value)
+	if err != nil {
+		return false, err
+	}
+	// 检查和写入在同一把锁中完成，保证原子性
+	var written bool
+	err = db.commit(db.options.SyncWrites, func() error {
+		if _, err := db.get(key, DefaultReadOptions); err != ErrKeyNotFound {
+			return err
+		}
+		if err := db.put(key, value, encoded, 0, db.options.SyncWrites); err != nil {
+			return err
+		}
+		written = true
+		return nil
+	})
+	return written, err
+}
+
+// CompareAndSwap 当 key 当前的值等于 oldValue 时，将其替换为 newValue，返回是否替换成功
+// key 不存在时不会写入数据，替换之后 key 原来的过期时间保持不变
+func (db *DB) CompareAndSwap(key []byte, oldValue, newValue []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return false, ErrDatabaseIsReadOnly
+	}
+
+	encoded, err := db.encodeValue(newValue)
+	if err != nil {
+		return false, err
+	}
+	var swapped bool
+	err = db.commit(db.options.SyncWrites, func() error {
+		matched, err := db.valueEquals(key, oldValue)
+		if err != nil || !matched {
+			return err
+		}
+		expire := db.index.Get(key).Expire
+		if err := db.put(key, newValue, encoded, expire, db.options.SyncWrites); err != nil {
+			return err
+		}
+		swapped = true
+		return nil
+	})
+	return swapped, err
+}
+
+// CompareAndDelete 当 key 当前的值等于 expected 时删除这个 key，返回是否删除成功
+func (db *DB) CompareAndDelete(key []byte, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return false, ErrDatabaseIsReadOnly
+	}
+
+	var deleted bool
+	err := db.commit(db.options.SyncWrites, func() error {
+		matched, err := db.valueEquals(key, expected)
+		if err != nil || !matched {
+			return err
+		}
+		if err := db.delete(key, db.options.SyncWrites); err != nil {
+			return err
+		}
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
+
+// Update 原子地读取 key 当前的值，并写入 fn 返回的新值，key 原来的过期时间保持不变
+// fn 返回 del 为 true 时删除 key，返回错误时不写入任何数据，错误会原样返回
+// fn 在持有互斥锁的情况下执行，不能在 fn 中访问数据库，fn 中的 panic 会以 ErrWritePanicked 错误返回
+func (db *DB) Update(key []byte, fn func(old []byte, exists bool) (new []byte, del bool, err error)) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
+
+	return db.commit(db.options.SyncWrites, func() error {
+		old, err := db.get(key, DefaultReadOptions)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		exists := err == nil
+		value, del, err := fn(old, exists)
+		if err != nil {
+			return err
+		}
+		if del {
+			if !exists {
+				return nil
+			}
+			return db.delete(key, db.options.SyncWrites)
+		}
+		var expire int64
+		if exists {
+			expire = db.index.Get(key).Expire
+		}
+		return db.put(key, value, nil, expire, db.options.SyncWrites)
+	})
+}
+
+// 判断 key 当前的值是否和给定的值相等，key 不存在时返回 false
+// 在访问此方法前必须持有互斥锁
+func (db *DB) valueEquals(key []byte, expected []byte) (bool, error) {
+	value, err := db.get(key, DefaultReadOptions)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(value, expected), nil
+}
@@ -0,0 +1,154 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-if-absent")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	val1 := utils.RandomValue(10)
+	ok, err := db.PutIfAbsent(utils.GetTestKey(1), val1)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = db.PutIfAbsent(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val)
+
+	_, err = db.PutIfAbsent(nil, val1)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// 已经过期的 key 可以重新写入，不保留过期的时间
+	err = db.PutWithTTL(utils.GetTestKey(3), val1, time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 10)
+	ok, err = db.PutIfAbsent(utils.GetTestKey(3), val1)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), db.index.Get(utils.GetTestKey(3)).Expire)
+
+	// 并发写入同一个 key，只有一个能成功
+	var succeed int
+	var mu sync.Mutex
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		// 生成随机 value 的随机数生成器不是并发安全的，在启动协程之前生成
+		value := utils.RandomValue(10)
+		go func() {
+			defer wg.Done()
+			ok, err := db.PutIfAbsent(utils.GetTestKey(2), value)
+			assert.Nil(t, err)
+			if ok {
+				mu.Lock()
+				succeed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, succeed)
+}
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// key 不存在
+	ok, err := db.CompareAndSwap(utils.GetTestKey(1), nil, []byte("1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	err = db.Put(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("2"), []byte("3"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("1"), []byte("2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	// 替换之后保留原来的过期时间
+	err = db.PutWithTTL(utils.GetTestKey(3), []byte("1"), time.Hour)
+	assert.Nil(t, err)
+	expire := db.index.Get(utils.GetTestKey(3)).Expire
+	assert.True(t, expire > 0)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(3), []byte("1"), []byte("2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, expire, db.index.Get(utils.GetTestKey(3)).Expire)
+
+	// 并发进行 CAS，只有一个能成功
+	var succeed int
+	var mu sync.Mutex
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		// 生成随机 value 的随机数生成器不是并发安全的，在启动协程之前生成
+		newValue := utils.RandomValue(10)
+		go func() {
+			defer wg.Done()
+			ok, err := db.CompareAndSwap(utils.GetTestKey(1), []byte("2"), newValue)
+			assert.Nil(t, err)
+			if ok {
+				mu.Lock()
+				succeed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, succeed)
+}
+
+func TestDB_CompareAndDelete(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cad")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+
+	ok, err := db.CompareAndDelete(utils.GetTestKey(1), []byte("2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	ok, err = db.CompareAndDelete(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	ok, err = db.CompareAndDelete(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
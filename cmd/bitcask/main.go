@@ -0,0 +1,121 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `usage: bitcask <command> [arguments]
+
+commands:
+  verify [-json] <dir>    离线校验数据目录，发现问题时以状态码 1 退出
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "verify":
+		os.Exit(runVerify(os.Args[2:]))
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// 校验结果的 JSON 格式，error 无法直接序列化
+type verifyProblem struct {
+	File   string
+	Offset int64
+	Key    string
+	Error  string
+}
+
+type verifyReport struct {
+	DirPath     string
+	OK          bool
+	IndexLoaded bool
+	DataFiles   []*bitcask.VerifyFileStat
+	IndexFiles  []*bitcask.VerifyIndexStat
+	Problems    []verifyProblem
+}
+
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "以 JSON 格式输出校验结果")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	report, err := bitcask.Verify(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to verify %s: %v\n", flags.Arg(0), err)
+		return 2
+	}
+
+	if *asJSON {
+		out := verifyReport{
+			DirPath:     report.DirPath,
+			OK:          report.OK(),
+			IndexLoaded: report.IndexLoaded,
+			DataFiles:   report.DataFiles,
+			IndexFiles:  report.IndexFiles,
+			Problems:    make([]verifyProblem, 0, len(report.Problems)),
+		}
+		for _, p := range report.Problems {
+			out.Problems = append(out.Problems, verifyProblem{File: p.File, Offset: p.Offset, Key: string(p.Key), Error: p.Err.Error()})
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(out)
+	} else {
+		printReport(report)
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+func printReport(report *bitcask.VerifyReport) {
+	fmt.Printf("directory: %s\n\n", report.DirPath)
+	fmt.Printf("%-12s %12s %10s %12s %12s\n", "DATA FILE", "SIZE", "RECORDS", "LIVE", "DEAD")
+	for _, stat := range report.DataFiles {
+		if report.IndexLoaded {
+			fmt.Printf("%-12d %12d %10d %12d %12d\n", stat.FileId, stat.Size, stat.Records, stat.LiveBytes, stat.DeadBytes)
+		} else {
+			fmt.Printf("%-12d %12d %10d %12s %12s\n", stat.FileId, stat.Size, stat.Records, "-", "-")
+		}
+	}
+	if len(report.IndexFiles) > 0 {
+		fmt.Printf("\n%-20s %10s %10s\n", "INDEX FILE", "ENTRIES", "INVALID")
+		for _, stat := range report.IndexFiles {
+			fmt.Printf("%-20s %10d %10d\n", stat.Name, stat.Entries, stat.Invalid)
+		}
+	}
+
+	if report.OK() {
+		fmt.Println("\nok")
+		return
+	}
+	fmt.Printf("\n%d problem(s) found:\n", len(report.Problems))
+	for _, p := range report.Problems {
+		switch {
+		case p.File == "":
+			fmt.Printf("  %v\n", p.Err)
+		case len(p.Key) > 0:
+			fmt.Printf("  %s offset %d key %q: %v\n", p.File, p.Offset, p.Key, p.Err)
+		case p.Offset >= 0:
+			fmt.Printf("  %s offset %d: %v\n", p.File, p.Offset, p.Err)
+		default:
+			fmt.Printf("  %s: %v\n", p.File, p.Err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package bitcask_go
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 组提交队列
+// 需要持久化的并发写入依次排队，队首的写入者作为 leader 在一次加锁中执行所有排队的写入，
+// 并且只持久化一次，之后再唤醒其他的写入者，每个写入者返回时数据都已经持久化
+type commitQueue struct {
+	mu      *sync.Mutex
+	writers []*commitWriter
+}
+
+type commitWriter struct {
+	fn     func() error // 需要在 db 的互斥锁中执行的写入
+	err    error
+	leader bool // 被唤醒时是否成为了新的 leader
+	done   chan struct{}
+}
+
+func newCommitQueue() *commitQueue {
+	return &commitQueue{mu: new(sync.Mutex)}
+}
+
+// 在 db 的互斥锁中执行写入，需要持久化的写入和其他并发的写入合并为一次持久化
+func (db *DB) commit(sync bool, fn func() error) error {
+	if !sync {
+		db.mu.Lock()
+		defer db.unlockAndNotify()
+		return runWrite(fn)
+	}
+
+	cq := db.commits
+	w := &commitWriter{fn: fn, done: make(chan struct{})}
+	cq.mu.Lock()
+	cq.writers = append(cq.writers, w)
+	isLeader := len(cq.writers) == 1
+	cq.mu.Unlock()
+
+	// 等待 leader 完成写入，或者成为新的 leader
+	if !isLeader {
+		<-w.done
+		if !w.leader {
+			return w.err
+		}
+	}
+
+	db.mu.Lock()
+	cq.mu.Lock()
+	group := append([]*commitWriter(nil), cq.writers...)
+	cq.mu.Unlock()
+
+	// 所有的写入只做持久化的标记，完成之后统一持久化
+	db.deferSync = true
+	for _, gw := range group {
+		gw.err = runWrite(gw.fn)
+	}
+	db.deferSync = false
+	if db.syncPending {
+		db.syncPending = false
+		if err := db.syncActiveFiles(); err != nil {
+			for _, gw := range group {
+				if gw.err == nil {
+					gw.err = err
+				}
+			}
+		}
+	}
+	db.unlockAndNotify()
+
+	// 移除已经完成的写入，队列中还有写入者的话，唤醒队首的写入者作为新的 leader
+	cq.mu.Lock()
+	cq.writers = cq.writers[len(group):]
+	if len(cq.writers) > 0 {
+		next := cq.writers[0]
+		next.leader = true
+		close(next.done)
+	}
+	cq.mu.Unlock()
+	for _, gw := range group[1:] {
+		close(gw.done)
+	}
+	return w.err
+}
+
+// 执行写入，写入中的 panic 转换为错误返回给发起写入的调用者
+// 组提交中写入在 leader 的协程中执行，panic 不能影响同一组中的其他写入者，也不能导致互斥锁无法释放
+func runWrite(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrWritePanicked, r)
+		}
+	}()
+	return fn()
+}
+
+// 持久化活跃文件，组提交期间只做标记，由 leader 在所有写入完成之后统一持久化
+// 在访问此方法前必须持有互斥锁
+func (db *DB) syncWrites() error {
+	if db.deferSync {
+		db.syncPending = true
+		return nil
+	}
+	return db.syncActiveFiles()
+}
@@ -0,0 +1,148 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	ns, err := db.Namespace("ns")
+	assert.Nil(t, err)
+
+	// 不同类型的写入并发执行，合并在同一组中提交
+	// 先持有互斥锁，保证 leader 执行写入时已经有其他写入者在排队
+	syncs := db.metrics.syncs.Value()
+	var writes int64
+	var wg sync.WaitGroup
+	db.mu.Lock()
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := utils.GetTestKey(g*100 + i)
+				switch g % 4 {
+				case 0:
+					assert.Nil(t, db.Put(key, key))
+				case 1:
+					wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+					assert.Nil(t, wb.Put(key, key))
+					assert.Nil(t, wb.Commit())
+				case 2:
+					txn, err := db.Begin(false)
+					assert.Nil(t, err)
+					assert.Nil(t, txn.Put(key, key))
+					assert.Nil(t, txn.Commit())
+				case 3:
+					ok, err := db.PutIfAbsent(key, key)
+					assert.Nil(t, err)
+					assert.True(t, ok)
+					assert.Nil(t, ns.Put(key, key))
+					atomic.AddInt64(&writes, 1)
+				}
+				atomic.AddInt64(&writes, 1)
+			}
+		}(g)
+	}
+	for {
+		db.commits.mu.Lock()
+		queued := len(db.commits.writers)
+		db.commits.mu.Unlock()
+		if queued > 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	db.mu.Unlock()
+	wg.Wait()
+	// 同一组中的写入共用一次持久化
+	assert.True(t, db.metrics.syncs.Value()-syncs < uint64(writes))
+
+	// 条件不满足的写入不会写入数据
+	ok, err := db.PutIfAbsent(utils.GetTestKey(0), []byte("absent"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+
+	check := func(db *DB) {
+		assert.Equal(t, 799, len(db.ListKeys()))
+		for g := 0; g < 16; g++ {
+			for i := 0; i < 50; i++ {
+				key := utils.GetTestKey(g*100 + i)
+				val, err := db.Get(key)
+				if g == 0 && i == 1 {
+					assert.Equal(t, ErrKeyNotFound, err)
+					continue
+				}
+				assert.Nil(t, err)
+				assert.Equal(t, key, val)
+			}
+		}
+		ns, err := db.Namespace("ns")
+		assert.Nil(t, err)
+		val, err := ns.Get(utils.GetTestKey(303))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(303), val)
+	}
+	check(db)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDB_GroupCommit_Panic(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-panic")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// Update 的回调可能在其他写入者的协程中执行，panic 只会作为错误返回给 Update 的调用者
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := utils.GetTestKey(g*100 + i)
+				if g%2 == 0 {
+					assert.Nil(t, db.Put(key, key))
+					continue
+				}
+				err := db.Update(key, func(old []byte, exists bool) ([]byte, bool, error) {
+					panic("update panicked")
+				})
+				assert.ErrorIs(t, err, ErrWritePanicked)
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 200, len(db.ListKeys()))
+
+	// 互斥锁已经释放，之后的写入不受影响
+	err = db.Update(utils.GetTestKey(1), func(old []byte, exists bool) ([]byte, bool, error) {
+		panic("update panicked")
+	})
+	assert.ErrorIs(t, err, ErrWritePanicked)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("value")))
+}
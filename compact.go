@@ -0,0 +1,486 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	compactDirName     = "-compact"
+	compactFinishedKey = "compact.finished"
+)
+
+// MergeFiles 选择性 merge，只重写无效数据较多的数据文件
+// 被选中的数据文件会原地重写，保留原来的文件 id，并生成各自独立的 hint 文件，重写完成之后在线替换原来的数据文件
+func (db *DB) MergeFiles(opts MergeOptions) (err error) {
+	start := time.Now()
+	if db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
+	// B+ 树索引持久化在磁盘上，重写之后的位置无法更新到索引中
+	if db.options.IndexType == BPlusTree {
+		return ErrMergeFilesNotSupported
+	}
+	if opts.FileGarbageRatio < 0 || opts.FileGarbageRatio > 1 || opts.TopN < 0 {
+		return ErrInvalidMergeOptions
+	}
+	// 如果数据库为空，则直接返回
+	if db.activeFile == nil {
+		return nil
+	}
+	db.mu.Lock()
+	// 如果 merge 正在进行当中，则直接返回
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+
+	// 先写入合并操作数合并之后的值并持久化，重写之后的数据文件中不会缺少操作数之前的记录
+	if len(db.operands) > 0 {
+		if err := db.foldMergeOperands(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		if err := db.syncActiveFiles(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+	}
+
+	// 取出需要 merge 的文件
+	mergeFiles, liveSize, err := db.pickMergeFiles(opts)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if len(mergeFiles) == 0 {
+		db.mu.Unlock()
+		return ErrMergeRatioUnreached
+	}
+
+	// 查看剩余的空间容量是否可以容纳 merge 之后的数据量
+	availableDiskSize, err := utils.AvailableDiskSize()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if uint64(liveSize) >= availableDiskSize {
+		db.mu.Unlock()
+		return ErrNoEnoughSpaceForMerge
+	}
+
+	db.isMerging = true
+	defer func() {
+		db.isMerging = false
+	}()
+	// merge 期间可能会创建新的 namespace，不持有锁时只能使用复制的索引
+	indexes := db.copyIndexes()
+	db.mu.Unlock()
+
+	mergeFileIds := make([]uint32, 0, len(mergeFiles))
+	for _, dataFile := range mergeFiles {
+		mergeFileIds = append(mergeFileIds, dataFile.FileId)
+	}
+	var reclaimed int64
+	beginInfo := MergeBeginInfo{Selective: true, FileIds: mergeFileIds}
+	db.events.post(func(l EventListener) {
+		l.OnMergeBegin(beginInfo)
+	})
+	defer func() {
+		endInfo := MergeEndInfo{MergeBeginInfo: beginInfo, Duration: time.Since(start), ReclaimedBytes: reclaimed, Err: err}
+		db.events.post(func(l EventListener) {
+			l.OnMergeEnd(endInfo)
+		})
+	}()
+
+	compactPath := db.getCompactPath()
+	// 如果目录存在，说明上一次的结果还没有生效，重新生成
+	if _, err := os.Stat(compactPath); err == nil {
+		if err := os.RemoveAll(compactPath); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(compactPath, os.ModePerm); err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	// 写入时间在这之后的历史版本需要保留
+	var retainAfter int64 = -1
+	if db.options.MergeRetention > 0 {
+		retainAfter = now - db.options.MergeRetention.Nanoseconds()
+	}
+	fileIds := make([]string, 0, len(mergeFiles))
+	compactEntries := make(map[uint32][]*compactEntry, len(mergeFiles))
+	for _, dataFile := range mergeFiles {
+		entries, err := db.compactDataFile(compactPath, dataFile, indexes, now, retainAfter)
+		if err != nil {
+			return err
+		}
+		compactEntries[dataFile.FileId] = entries
+		fileIds = append(fileIds, strconv.Itoa(int(dataFile.FileId)))
+	}
+
+	// 写标识选择性 merge 完成的文件，记录所有重写过的文件 id
+	compactFinishedFile, err := data.OpenCompactFinishedFile(compactPath)
+	if err != nil {
+		return err
+	}
+	defer compactFinishedFile.Close()
+	compactFinRecord := &data.LogRecord{
+		Key:   []byte(compactFinishedKey),
+		Value: []byte(strings.Join(fileIds, ",")),
+	}
+	encRecord, _ := data.EncodeLogRecord(compactFinRecord)
+	if err := compactFinishedFile.Write(encRecord); err != nil {
+		return err
+	}
+	if err := compactFinishedFile.Sync(); err != nil {
+		return err
+	}
+	// 替换之前统计回收的空间
+	reclaimedSize := dataFilesSize(db.options.DirPath, mergeFileIds) - dataFilesSize(compactPath, mergeFileIds)
+	// 在线替换数据文件，中途失败的话下一次启动时会继续替换
+	if err := db.swapCompactFiles(compactPath, mergeFiles, compactEntries); err != nil {
+		return err
+	}
+	reclaimed = reclaimedSize
+	db.metrics.observeMerge(start, reclaimed)
+	return nil
+}
+
+// 选择性 merge 重写之后保留的记录，替换数据文件时据此更新内存索引
+type compactEntry struct {
+	nsId      uint32
+	key       []byte
+	typ       data.LogRecordType
+	oldOffset int64              // 记录在原来的数据文件中的位置
+	pos       *data.LogRecordPos // 记录在重写之后的数据文件中的位置
+}
+
+// 用重写之后的数据文件替换原来的数据文件，文件 id 保持不变
+// 索引中依然指向原来位置的 key 更新为新的位置，并按照重写之后的数据重新统计这些文件中的无效数据量，和启动时加载的结果一致
+// 原来的数据文件在更早版本的读取者全部结束之后才会关闭
+func (db *DB) swapCompactFiles(compactPath string, mergeFiles []*data.DataFile, compactEntries map[uint32][]*compactEntry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 先打开重写之后的数据文件，移动之后依然可以读取
+	newFiles := make(map[uint32]*data.DataFile, len(mergeFiles))
+	fileIds := make([]uint32, 0, len(mergeFiles))
+	for _, file := range mergeFiles {
+		dataFile, err := data.OpenDataFile(compactPath, file.FileId, fio.StandardFIO)
+		if err != nil {
+			for _, newFile := range newFiles {
+				_ = newFile.Close()
+			}
+			return err
+		}
+		dataFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
+		newFiles[file.FileId] = dataFile
+		fileIds = append(fileIds, file.FileId)
+	}
+	if err := db.moveCompactFiles(compactPath, fileIds); err != nil {
+		for _, newFile := range newFiles {
+			_ = newFile.Close()
+		}
+		return err
+	}
+
+	for _, file := range mergeFiles {
+		db.olderFiles[file.FileId] = newFiles[file.FileId]
+		db.dropFileReclaimSize(file.FileId)
+		db.dropMergeOperands(file.FileId)
+	}
+	for fileId, entries := range compactEntries {
+		for _, entry := range entries {
+			idx := db.indexOf(entry.nsId)
+			if idx == nil {
+				continue
+			}
+			pos := idx.Get(entry.key)
+			isLive := pos != nil && pos.Fid == fileId && pos.Offset == entry.oldOffset
+			switch {
+			case entry.typ == data.LogRecordDeleted || entry.typ == data.LogRecordRangeDeleted:
+				// 删除记录和启动时加载一样计入无效数据，已经过期的有效数据被改写成了删除记录，从索引中删除
+				db.addNamespaceReclaimSize(entry.nsId, entry.pos)
+				if isLive {
+					idx.Delete(entry.key)
+					if pos.BlobSize > 0 {
+						db.blobReclaimSize[pos.BlobFid] += int64(pos.BlobSize)
+					}
+				}
+			case isLive:
+				idx.Put(entry.key, entry.pos)
+			default:
+				// 重写之后又被覆盖或者删除的数据，blob 文件中的数据在覆盖时已经计入了无效数据
+				db.addNamespaceReclaimSize(entry.nsId, &data.LogRecordPos{Fid: fileId, Offset: entry.pos.Offset, Size: entry.pos.Size})
+			}
+		}
+	}
+	db.epochs.retire(mergeFiles, true)
+	return os.RemoveAll(compactPath)
+}
+
+// 按照无效数据量从大到小选出需要 merge 的旧数据文件，并按照文件 id 从小到大返回
+// 同时返回这些文件中有效数据的大小
+// 在访问此方法前必须持有互斥锁
+func (db *DB) pickMergeFiles(opts MergeOptions) ([]*data.DataFile, int64, error) {
+	type candidate struct {
+		file        *data.DataFile
+		size        int64
+		reclaimSize int64
+	}
+	var candidates []candidate
+	for fid, file := range db.olderFiles {
+		reclaimSize := db.fileReclaimSize[fid]
+		if reclaimSize <= 0 {
+			continue
+		}
+		size, err := file.IoManager.Size()
+		if err != nil {
+			return nil, 0, err
+		}
+		if size == 0 || float32(reclaimSize)/float32(size) < opts.FileGarbageRatio {
+			continue
+		}
+		candidates = append(candidates, candidate{file: file, size: size, reclaimSize: reclaimSize})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].reclaimSize != candidates[j].reclaimSize {
+			return candidates[i].reclaimSize > candidates[j].reclaimSize
+		}
+		return candidates[i].file.FileId < candidates[j].file.FileId
+	})
+	if opts.TopN > 0 && len(candidates) > opts.TopN {
+		candidates = candidates[:opts.TopN]
+	}
+
+	var liveSize int64
+	mergeFiles := make([]*data.DataFile, 0, len(candidates))
+	for _, c := range candidates {
+		mergeFiles = append(mergeFiles, c.file)
+		liveSize += c.size - c.reclaimSize
+	}
+	sort.Slice(mergeFiles, func(i, j int) bool {
+		return mergeFiles[i].FileId < mergeFiles[j].FileId
+	})
+	return mergeFiles, liveSize, nil
+}
+
+// 重写单个数据文件，写到临时目录中 id 相同的数据文件，并生成对应的 hint 文件
+// 有效的数据会保留，已经过期的有效数据改写为删除记录，索引中不存在的 key 的删除记录也需要保留，
+// 避免更早的数据文件中的旧数据在加载时被重新加入索引
+func (db *DB) compactDataFile(compactPath string, dataFile *data.DataFile, indexes map[uint32]index.Indexer, now, retainAfter int64) ([]*compactEntry, error) {
+	compactFile, err := data.OpenDataFile(compactPath, dataFile.FileId, fio.StandardFIO)
+	if err != nil {
+		return nil, err
+	}
+	defer compactFile.Close()
+	hintFile, err := data.OpenFileHintFile(compactPath, dataFile.FileId)
+	if err != nil {
+		return nil, err
+	}
+	defer hintFile.Close()
+	hintFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
+
+	writeRecord := func(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+		var blobPos *data.BlobPos
+		if logRecord.BlobRef {
+			blobPos = data.DecodeBlobPos(logRecord.Value)
+		}
+		encRecord, size, err := db.encodeLogRecord(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		db.bgLimiter.Wait(size)
+		writeOff := compactFile.WriteOff
+		if err := compactFile.Write(encRecord); err != nil {
+			return nil, err
+		}
+		pos := &data.LogRecordPos{
+			Fid:    compactFile.FileId,
+			Offset: writeOff,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		}
+		if blobPos != nil {
+			pos.BlobFid = blobPos.Fid
+			pos.BlobSize = blobPos.Size
+		}
+		return pos, nil
+	}
+
+	var entries []*compactEntry
+	// 暂存需要保留的历史事务数据，事务完成之后才会重写
+	historyTxnRecords := make(map[uint64][]*data.LogRecord)
+	var offset int64 = 0
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			// 跳过启动时已经按照恢复策略丢弃的损坏数据
+			next, err := db.skipCorruptRecord(dataFile, offset, err)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			offset = next
+			continue
+		}
+		db.bgLimiter.Wait(size)
+		// 解析拿到实际的 key
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		idx := indexes[logRecord.Namespace]
+		var logRecordPos *data.LogRecordPos
+		if idx != nil {
+			logRecordPos = idx.Get(realKey)
+		}
+
+		isLive := logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset
+		// 范围删除的记录需要一直保留，原因和删除记录相同
+		isTombstone := (logRecord.Type == data.LogRecordDeleted && idx != nil && logRecordPos == nil) ||
+			logRecord.Type == data.LogRecordRangeDeleted
+		if isLive || isTombstone {
+			// 清除事务标记，有效的数据都是已经提交的
+			logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+			if isLive && logRecordPos.IsExpired(now) {
+				logRecord = &data.LogRecord{
+					Key:       logRecord.Key,
+					Type:      data.LogRecordDeleted,
+					Timestamp: logRecord.Timestamp,
+					Namespace: logRecord.Namespace,
+				}
+			}
+			pos, err := writeRecord(logRecord)
+			if err != nil {
+				return nil, err
+			}
+			// 将当前位置索引写到 Hint 文件当中
+			if err := hintFile.WriteHintRecordWithType(realKey, logRecord.Namespace, logRecord.Type, pos); err != nil {
+				return nil, err
+			}
+			entries = append(entries, &compactEntry{
+				nsId:      logRecord.Namespace,
+				key:       realKey,
+				typ:       logRecord.Type,
+				oldOffset: offset,
+				pos:       pos,
+			})
+		} else if retainAfter >= 0 && logRecord.Timestamp >= retainAfter {
+			// 保留窗口内的历史版本，只有在当前文件中完成的事务数据才会保留
+			// 历史版本不会加载到索引中，因此不需要写到 Hint 文件当中
+			if logRecord.Type == data.LogRecordTxnFinished {
+				for _, record := range historyTxnRecords[seqNo] {
+					if _, err := writeRecord(record); err != nil {
+						return nil, err
+					}
+				}
+				delete(historyTxnRecords, seqNo)
+			} else {
+				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+				if seqNo == nonTransactionSeqNo {
+					if _, err := writeRecord(logRecord); err != nil {
+						return nil, err
+					}
+				} else {
+					historyTxnRecords[seqNo] = append(historyTxnRecords[seqNo], logRecord)
+				}
+			}
+		}
+		offset += size
+	}
+
+	// sync 保证持久化
+	if err := compactFile.Sync(); err != nil {
+		return nil, err
+	}
+	if err := hintFile.Sync(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (db *DB) getCompactPath() string {
+	dir := path.Dir(path.Clean(db.options.DirPath))
+	base := path.Base(db.options.DirPath)
+	return filepath.Join(dir, base+compactDirName)
+}
+
+// 加载选择性 merge 的数据目录，用重写之后的数据文件和 hint 文件替换原来的数据文件
+// 只有所有文件都移动完成之后才会删除目录，中途失败的话下一次启动时会继续移动
+func (db *DB) loadCompactFiles() error {
+	compactPath := db.getCompactPath()
+	// 目录不存在的话直接返回
+	if _, err := os.Stat(compactPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	// 没有完成的选择性 merge 直接丢弃
+	fileIds, err := db.getCompactedFileIds(compactPath)
+	if err != nil {
+		return os.RemoveAll(compactPath)
+	}
+
+	if err := db.moveCompactFiles(compactPath, fileIds); err != nil {
+		return err
+	}
+	return os.RemoveAll(compactPath)
+}
+
+// 用重写之后的数据文件和 hint 文件替换数据目录中的文件
+// 先移动数据文件，再移动 hint 文件，已经移动过的文件直接跳过
+func (db *DB) moveCompactFiles(compactPath string, fileIds []uint32) error {
+	for _, fileId := range fileIds {
+		fileNames := [][2]string{
+			{data.GetDataFileName(compactPath, fileId), data.GetDataFileName(db.options.DirPath, fileId)},
+			{data.GetHintFileName(compactPath, fileId), data.GetHintFileName(db.options.DirPath, fileId)},
+		}
+		for _, names := range fileNames {
+			if _, err := os.Stat(names[0]); os.IsNotExist(err) {
+				continue
+			}
+			if err := os.Rename(names[0], names[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 读取选择性 merge 重写过的文件 id
+func (db *DB) getCompactedFileIds(dirPath string) ([]uint32, error) {
+	compactFinishedFile, err := data.OpenCompactFinishedFile(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer compactFinishedFile.Close()
+	record, _, err := compactFinishedFile.ReadLogRecord(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIds []uint32
+	for _, s := range strings.Split(string(record.Value), ",") {
+		fileId, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		fileIds = append(fileIds, uint32(fileId))
+	}
+	return fileIds, nil
+}
@@ -0,0 +1,169 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_MergeFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	err = db.MergeFiles(MergeOptions{FileGarbageRatio: 2})
+	assert.Equal(t, ErrInvalidMergeOptions, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 3000; i++ {
+		values[i] = utils.RandomValue(1024)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	// 没有无效数据的时候不会选中任何文件
+	err = db.MergeFiles(DefaultMergeOptions)
+	assert.Equal(t, ErrMergeRatioUnreached, err)
+
+	// 0 号文件中的大部分数据失效，1 号文件中少量数据失效
+	for i := 0; i < 800; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	for i := 1000; i < 1100; i++ {
+		values[i] = utils.RandomValue(1024)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+
+	stat := db.Stat()
+	assert.True(t, stat.FileReclaimableSize[0] > stat.FileReclaimableSize[1])
+	assert.True(t, stat.FileReclaimableSize[1] > 0)
+	var total int64
+	for _, size := range stat.FileReclaimableSize {
+		total += size
+	}
+	assert.Equal(t, stat.ReclaimableSize, total)
+
+	db.mu.Lock()
+	files, _, err := db.pickMergeFiles(MergeOptions{TopN: 1})
+	db.mu.Unlock()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, uint32(0), files[0].FileId)
+
+	size0, err := db.olderFiles[0].IoManager.Size()
+	assert.Nil(t, err)
+	size1, err := db.olderFiles[1].IoManager.Size()
+	assert.Nil(t, err)
+
+	// merge 之前创建的迭代器和事务依然读取原来的数据文件
+	iter := db.NewIterator(DefaultIteratorOptions)
+	txn, err := db.Begin(true)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(900), utils.RandomValue(1024))
+	assert.Nil(t, err)
+
+	err = db.MergeFiles(DefaultMergeOptions)
+	assert.Nil(t, err)
+
+	// 重写的结果立即生效
+	_, err = os.Stat(db.getCompactPath())
+	assert.True(t, os.IsNotExist(err))
+	newSize0, err := db.olderFiles[0].IoManager.Size()
+	assert.Nil(t, err)
+	assert.True(t, newSize0 < size0)
+	stat = db.Stat()
+	assert.Equal(t, int64(0), stat.FileReclaimableSize[0])
+	total = 0
+	for _, size := range stat.FileReclaimableSize {
+		total += size
+	}
+	assert.Equal(t, stat.ReclaimableSize, total)
+	for i := 700; i < 900; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if _, ok := values[i]; ok {
+			assert.Equal(t, values[i], val)
+		} else {
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+	}
+
+	count := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, values[count+800], val)
+		count++
+	}
+	assert.Equal(t, len(values), count)
+	iter.Close()
+	val, err := txn.Get(utils.GetTestKey(900))
+	assert.Nil(t, err)
+	assert.Equal(t, values[900], val)
+	txn.Rollback()
+	values[900], err = db.Get(utils.GetTestKey(900))
+	assert.Nil(t, err)
+
+	check := func(db *DB) {
+		assert.Equal(t, len(values), len(db.ListKeys()))
+		for i := 0; i < 3000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if _, ok := values[i]; ok {
+				assert.Equal(t, values[i], val)
+			} else {
+				assert.Equal(t, ErrKeyNotFound, err)
+			}
+		}
+	}
+
+	check(db)
+	reclaimSize := db.Stat().FileReclaimableSize
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	// 在线替换之后统计的无效数据量和重启之后加载的结果一致
+	assert.Equal(t, reclaimSize, db.Stat().FileReclaimableSize)
+
+	// 只有 0 号文件被重写，1 号文件的 hint 文件是写入数据时生成的
+	assert.False(t, db.readFileHint(db.olderFiles[0]).sealed)
+	assert.True(t, db.readFileHint(db.olderFiles[1]).sealed)
+	newSize1, err := db.olderFiles[1].IoManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, size1, newSize1)
+	assert.Equal(t, int64(0), db.Stat().FileReclaimableSize[0])
+
+	// 写入新的数据之后再次重启
+	for i := 0; i < 100; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// 全量 merge 之后，选择性 merge 生成的 hint 文件会被删除
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	_, err = os.Stat(data.GetHintFileName(dir, 0))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+)
+
+const (
+	BlobFileNameSuffix   = ".blob"
+	BlobObsoleteFileName = "blob-obsolete" // 记录已经被回收的 blob 文件
+)
+
+// BlobPos 数据文件中记录的 value 在 blob 文件中的位置
+type BlobPos struct {
+	Fid    uint32 // blob 文件 id
+	Offset int64  // 在 blob 文件中的偏移
+	Size   uint32 // 在 blob 文件中的大小
+}
+
+// OpenBlobFile 打开 blob 文件
+func OpenBlobFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := GetBlobFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId, ioType)
+}
+
+// OpenBlobObsoleteFile 打开记录已回收 blob 文件的文件
+func OpenBlobObsoleteFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, BlobObsoleteFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
+// GetBlobFileName 返回 blob 文件的名称
+func GetBlobFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+BlobFileNameSuffix)
+}
+
+// EncodeBlobPos 对 blob 位置信息进行编码
+func EncodeBlobPos(pos *BlobPos) []byte {
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	var index = 0
+	index += binary.PutVarint(buf[index:], int64(pos.Fid))
+	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	return buf[:index]
+}
+
+// DecodeBlobPos 对 blob 位置信息进行解码
+func DecodeBlobPos(buf []byte) *BlobPos {
+	var index = 0
+	fileId, n := binary.Varint(buf[index:])
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	size, _ := binary.Varint(buf[index:])
+	return &BlobPos{Fid: uint32(fileId), Offset: offset, Size: uint32(size)}
+}
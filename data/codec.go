@@ -0,0 +1,161 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	ErrUnknownCodec = errors.New("unknown codec type, log record cannot be decompressed")
+)
+
+type CodecType = byte
+
+const (
+	// CodecNone 不压缩
+	CodecNone CodecType = iota
+
+	// CodecFlate 使用 DEFLATE 算法压缩
+	CodecFlate
+
+	// CodecGzip 使用 gzip 格式压缩
+	CodecGzip
+)
+
+// Codec 抽象压缩接口，可以通过 RegisterCodec 接入自定义的压缩算法
+type Codec interface {
+	// Type 压缩算法的标识，会被写入到 LogRecord 的 header 中
+	Type() CodecType
+
+	// Compress 压缩数据
+	Compress([]byte) ([]byte, error)
+
+	// Decompress 解压数据
+	Decompress([]byte) ([]byte, error)
+}
+
+var (
+	codecsLock = new(sync.RWMutex)
+	codecs     = map[CodecType]Codec{
+		CodecFlate: flateCodec{},
+		CodecGzip:  gzipCodec{},
+	}
+)
+
+// RegisterCodec 注册自定义的压缩算法，相同标识的压缩算法会被覆盖
+// 已经写入数据文件的标识需要一直保持注册，否则对应的数据无法读取
+func RegisterCodec(codec Codec) {
+	if codec.Type() == CodecNone {
+		panic("cannot register codec with type CodecNone")
+	}
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[codec.Type()] = codec
+}
+
+// GetCodec 根据标识获取压缩算法
+func GetCodec(typ CodecType) (Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	codec, ok := codecs[typ]
+	return codec, ok
+}
+
+// CompressValue 使用指定的压缩算法压缩 value，压缩之后没有变小则保留原始数据
+func (lr *LogRecord) CompressValue(typ CodecType) error {
+	if typ == CodecNone || lr.Codec != CodecNone || len(lr.Value) == 0 {
+		return nil
+	}
+	codec, ok := GetCodec(typ)
+	if !ok {
+		return ErrUnknownCodec
+	}
+	compressed, err := codec.Compress(lr.Value)
+	if err != nil {
+		return err
+	}
+	if len(compressed) >= len(lr.Value) {
+		return nil
+	}
+	lr.Value = compressed
+	lr.Codec = typ
+	return nil
+}
+
+// 根据 LogRecord 中记录的压缩算法解压 value
+func (lr *LogRecord) decompressValue() error {
+	if lr.Codec == CodecNone {
+		return nil
+	}
+	codec, ok := GetCodec(lr.Codec)
+	if !ok {
+		return ErrUnknownCodec
+	}
+	value, err := codec.Decompress(lr.Value)
+	if err != nil {
+		return err
+	}
+	lr.Value = value
+	lr.Codec = CodecNone
+	return nil
+}
+
+// DEFLATE 压缩
+type flateCodec struct{}
+
+func (flateCodec) Type() CodecType {
+	return CodecFlate
+}
+
+func (flateCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(src); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decompress(src []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(src))
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// gzip 压缩
+type gzipCodec struct{}
+
+func (gzipCodec) Type() CodecType {
+	return CodecGzip
+}
+
+func (gzipCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(src); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(src []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
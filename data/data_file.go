@@ -10,7 +10,9 @@ import (
 )
 
 var (
-	ErrInvalidCRC = errors.New("invalid crc value, log record maybe corrupted")
+	ErrInvalidCRC          = errors.New("invalid crc value, log record maybe corrupted")
+	ErrIncompleteLogRecord = errors.New("incomplete log record, data file maybe truncated")
+	ErrInvalidRecordHeader = errors.New("invalid log record header, log record maybe written by a newer version")
 )
 
 const (
@@ -18,6 +20,10 @@ const (
 	HintFileName          = "hint-index" //hint文件名称
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	NamespaceFileName     = "namespace" // namespace 名称和 id 的对应关系
+
+	HintFileNameSuffix      = ".hint"            // 单个数据文件的 hint 文件
+	CompactFinishedFileName = "compact-finished" // 标识选择性 merge 完成的文件
 )
 
 // DataFile 数据文件
@@ -25,6 +31,9 @@ type DataFile struct {
 	FileId    uint32        // 文件id
 	WriteOff  int64         // 文件写到了哪个位置
 	IoManager fio.IOManager // io 读写管理
+
+	encryptor   Encryptor // 加密，为 nil 表示不加密
+	encryptKeys bool      // 是否同时加密 key
 }
 
 // OpenDataFile 打开新的数据文件
@@ -39,6 +48,18 @@ func OpenHintFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// OpenFileHintFile 打开单个数据文件对应的 Hint 文件
+func OpenFileHintFile(dirPath string, fileId uint32) (*DataFile, error) {
+	fileName := GetHintFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId, fio.StandardFIO)
+}
+
+// OpenCompactFinishedFile 打开标识选择性 merge 完成的文件
+func OpenCompactFinishedFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, CompactFinishedFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
 // OpenMergeFinishedFile 打开标识merge 完成的文件
 func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
@@ -51,11 +72,22 @@ func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// OpenNamespaceFile 存储 namespace 名称和 id 的文件
+func OpenNamespaceFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, NamespaceFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
 // GetDataFileName 返回文件的名称
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
+// GetHintFileName 返回单个数据文件对应的 hint 文件名称
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
 // 辅助函数 用于协助new 一个 Data File
 func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	//初始化IOManger 管理器接口
@@ -72,6 +104,15 @@ func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFi
 
 // ReadLogRecord 根据 offset 从数据文件中读取 LogRecord
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
+	return df.readLogRecord(offset, true)
+}
+
+// ReadLogRecordWithoutChecksum 根据 offset 从数据文件中读取 LogRecord，不校验 CRC
+func (df *DataFile) ReadLogRecordWithoutChecksum(offset int64) (*LogRecord, int64, error) {
+	return df.readLogRecord(offset, false)
+}
+
+func (df *DataFile) readLogRecord(offset int64, verifyChecksum bool) (*LogRecord, int64, error) {
 	fileSize, err := df.IoManager.Size()
 	if err != nil {
 		return nil, 0, err
@@ -82,6 +123,10 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	if offset+maxLogRecordHeaderSize > fileSize {
 		headerBytes = fileSize - offset
 	}
+	// offset 已经超出了文件的末尾，没有可以读取的数据
+	if headerBytes <= 0 {
+		return nil, 0, io.EOF
+	}
 
 	// 读取 Header 信息
 	headerBuf, err := df.readNBytes(headerBytes, offset)
@@ -89,11 +134,11 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 		return nil, 0, err
 	}
 
-	header, headerSize := decodeLogRecordHeader(headerBuf)
-	// 下面的两个条件表示读取到了文件末尾，直接返回 EOF 错误
-	if header == nil {
-		return nil, 0, io.EOF
+	header, headerSize, err := decodeLogRecordHeader(headerBuf)
+	if err != nil {
+		return nil, 0, err
 	}
+	// 全部为 0 的 header 表示读取到了文件末尾，直接返回 EOF 错误
 	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
 		return nil, 0, io.EOF
 	}
@@ -101,8 +146,21 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 取出对应的 key 和 value 的长度
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
+	// 数据超出了文件的末尾，说明写入的时候中断了
+	if offset+recordSize > fileSize {
+		return nil, 0, ErrIncompleteLogRecord
+	}
+
+	logRecord := &LogRecord{
+		Type:      header.recordType,
+		Expire:    header.expire,
+		Timestamp: header.timestamp,
+		Codec:     header.codec,
 
-	logRecord := &LogRecord{Type: header.recordType}
+		KeyID:        header.keyId,
+		KeyEncrypted: header.flags&flagKeyEncrypted != 0,
+		BlobRef:      header.flags&flagBlobRef != 0,
+	}
 	// 开始读取用户实际存储的 key/value 数据
 	if keySize > 0 || valueSize > 0 {
 		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
@@ -115,13 +173,63 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	}
 
 	// 校验数据的有效性
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
-	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+	if verifyChecksum {
+		crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
+		if crc != header.crc {
+			return nil, 0, ErrInvalidCRC
+		}
+	}
+
+	// 能够通过校验的数据，header 中的类型和标记位也必须是已知的
+	if !header.isValid() {
+		return nil, 0, ErrInvalidRecordHeader
+	}
+
+	// 校验通过之后再依次解析 namespace、解密、解压
+	if header.flags&flagNamespace != 0 {
+		logRecord.Namespace, logRecord.Key = decodeNamespaceKey(logRecord.Key)
+	}
+	if err := logRecord.decrypt(df.encryptor); err != nil {
+		return nil, 0, err
+	}
+	if err := logRecord.decompressValue(); err != nil {
+		return nil, 0, err
 	}
 	return logRecord, recordSize, nil
 }
 
+// NextLogRecordOffset 从 offset 之后逐字节查找下一条能够通过校验的 LogRecord，用于跳过损坏的数据
+// 找不到时返回 io.EOF
+func (df *DataFile) NextLogRecordOffset(offset int64) (int64, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	for off := offset + 1; off < fileSize; off++ {
+		_, _, err := df.ReadLogRecord(off)
+		if err == io.EOF || err == ErrInvalidCRC || err == ErrIncompleteLogRecord {
+			continue
+		}
+		// 通过校验之后的解密、解压错误不属于数据损坏，交给调用方处理
+		return off, nil
+	}
+	return 0, io.EOF
+}
+
+// ReadAt 从数据文件的给定位置读取数据，实现 io.ReaderAt
+func (df *DataFile) ReadAt(b []byte, offset int64) (int, error) {
+	return df.IoManager.Read(b, offset)
+}
+
+// Truncate 将数据文件截断到指定的大小
+func (df *DataFile) Truncate(size int64) error {
+	if err := df.IoManager.Truncate(size); err != nil {
+		return err
+	}
+	df.WriteOff = size
+	return nil
+}
+
 func (df *DataFile) Write(buf []byte) error {
 	n, err := df.IoManager.Write(buf)
 	if err != nil {
@@ -132,15 +240,40 @@ func (df *DataFile) Write(buf []byte) error {
 }
 
 // WriteHintRecord 写入索引信息道hint文件中
-func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
+func (df *DataFile) WriteHintRecord(key []byte, namespace uint32, pos *LogRecordPos) error {
+	return df.WriteHintRecordWithType(key, namespace, LogRecordNormal, pos)
+}
+
+// WriteHintRecordWithType 写入指定类型的索引信息，删除类型的记录在加载时会从索引中移除对应的 key
+func (df *DataFile) WriteHintRecordWithType(key []byte, namespace uint32, typ LogRecordType, pos *LogRecordPos) error {
+	encRecord, err := df.EncodeHintRecord(key, namespace, typ, pos)
+	if err != nil {
+		return err
+	}
+	return df.Write(encRecord)
+}
+
+// EncodeHintRecord 按照 hint 文件的加密配置编码索引信息，可以暂存之后批量写入
+func (df *DataFile) EncodeHintRecord(key []byte, namespace uint32, typ LogRecordType, pos *LogRecordPos) ([]byte, error) {
 	//对位置信息编码
 	record := &LogRecord{
-		Key:   key,
-		Value: EncodeLogRecordPos(pos),
+		Key:       key,
+		Value:     EncodeLogRecordPos(pos),
+		Type:      typ,
+		Namespace: namespace,
+	}
+	if err := record.Encrypt(df.encryptor, df.encryptKeys); err != nil {
+		return nil, err
 	}
 	//对record编码
 	encRecord, _ := EncodeLogRecord(record)
-	return df.Write(encRecord)
+	return encRecord, nil
+}
+
+// SetEncryptor 设置读写数据使用的加密，encryptKeys 表示 hint 记录是否同时加密 key
+func (df *DataFile) SetEncryptor(enc Encryptor, encryptKeys bool) {
+	df.encryptor = enc
+	df.encryptKeys = encryptKeys
 }
 
 func (df *DataFile) Sync() error {
@@ -1 +1,104 @@
 package data
+
+import (
+	"bitcask-go/fio"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDataFile_ReadLogRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dataFile, err := OpenDataFile(dir, 1, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer func() {
+		_ = dataFile.Close()
+	}()
+
+	rec1 := &LogRecord{
+		Key:       []byte("name"),
+		Value:     []byte("bitcask-go"),
+		Expire:    1686000000000000000,
+		Timestamp: 1685000000000000000,
+	}
+	enc1, size1 := EncodeLogRecord(rec1)
+	err = dataFile.Write(enc1)
+	assert.Nil(t, err)
+
+	rec2 := &LogRecord{Key: []byte("name"), Type: LogRecordDeleted}
+	enc2, size2 := EncodeLogRecord(rec2)
+	err = dataFile.Write(enc2)
+	assert.Nil(t, err)
+
+	res1, n1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, size1, n1)
+	assert.Equal(t, rec1, res1)
+
+	res2, n2, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, size2, n2)
+	assert.Equal(t, LogRecordDeleted, res2.Type)
+	assert.Equal(t, 0, len(res2.Value))
+
+	// namespace id 编码在 key 的前缀中
+	rec3 := &LogRecord{Key: []byte("name"), Value: []byte("users"), Namespace: 300}
+	enc3, size3 := EncodeLogRecord(rec3)
+	err = dataFile.Write(enc3)
+	assert.Nil(t, err)
+	res3, n3, err := dataFile.ReadLogRecord(size1 + size2)
+	assert.Nil(t, err)
+	assert.Equal(t, size3, n3)
+	assert.Equal(t, uint32(300), res3.Namespace)
+	assert.Equal(t, []byte("name"), res3.Key)
+}
+
+func TestDataFile_ReadLegacyLogRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-legacy")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dataFile, err := OpenDataFile(dir, 1, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer func() {
+		_ = dataFile.Close()
+	}()
+
+	// 旧版本写入的数据，header 中没有扩展字段
+	legacy1 := []byte{0x68, 0x52, 0xf0, 0x96, 0x0, 0x8, 0x14, 0x6e, 0x61, 0x6d, 0x65, 0x62, 0x69, 0x74, 0x63, 0x61, 0x73, 0x6b, 0x2d, 0x67, 0x6f}
+	legacy2 := []byte{0xbd, 0xf7, 0x2f, 0xa8, 0x1, 0x8, 0x0, 0x6e, 0x61, 0x6d, 0x65}
+	err = dataFile.Write(legacy1)
+	assert.Nil(t, err)
+	err = dataFile.Write(legacy2)
+	assert.Nil(t, err)
+	// 之后追加写入新格式的数据
+	rec3 := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go"), Expire: 1686000000000000000}
+	enc3, size3 := EncodeLogRecord(rec3)
+	err = dataFile.Write(enc3)
+	assert.Nil(t, err)
+
+	res1, n1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(legacy1)), n1)
+	assert.Equal(t, &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}, res1)
+
+	res2, n2, err := dataFile.ReadLogRecord(n1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(legacy2)), n2)
+	assert.Equal(t, LogRecordDeleted, res2.Type)
+	assert.Equal(t, []byte("name"), res2.Key)
+
+	res3, n3, err := dataFile.ReadLogRecord(n1 + n2)
+	assert.Nil(t, err)
+	assert.Equal(t, size3, n3)
+	assert.Equal(t, rec3, res3)
+
+	// 旧版本的格式中没有新增的数据类型
+	legacy1[4] = LogRecordMergeOperand
+	header, _, err := decodeLogRecordHeader(legacy1)
+	assert.Nil(t, err)
+	assert.False(t, header.isValid())
+}
@@ -0,0 +1,164 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+var (
+	ErrEncryptorMissing  = errors.New("log record is encrypted, but no encryptor is provided")
+	ErrUnknownEncryptKey = errors.New("unknown encryption key id")
+	ErrInvalidEncryptKey = errors.New("invalid encryption key id, must be greater than 0")
+	ErrInvalidCiphertext = errors.New("invalid ciphertext, log record maybe corrupted")
+)
+
+// Encryptor 抽象加密接口，支持多个密钥，用于密钥轮换
+type Encryptor interface {
+	// KeyID 当前用于加密的密钥 id，必须大于 0，会被写入到 LogRecord 的 header 中
+	KeyID() uint32
+
+	// Encrypt 使用指定的密钥加密数据
+	Encrypt(keyID uint32, plaintext []byte) ([]byte, error)
+
+	// Decrypt 使用指定的密钥解密数据
+	Decrypt(keyID uint32, ciphertext []byte) ([]byte, error)
+}
+
+// AESGCMEncryptor 基于 AES-GCM 的加密实现
+type AESGCMEncryptor struct {
+	currentKeyID uint32
+	aeads        map[uint32]cipher.AEAD
+}
+
+// NewAESGCMEncryptor 初始化 AES-GCM 加密，keys 中的密钥长度必须是 16、24 或 32 字节
+// currentKeyID 为当前用于加密的密钥，其他的密钥只用于解密历史数据
+func NewAESGCMEncryptor(currentKeyID uint32, keys map[uint32][]byte) (*AESGCMEncryptor, error) {
+	aeads := make(map[uint32]cipher.AEAD, len(keys))
+	for keyID, key := range keys {
+		if keyID == 0 {
+			return nil, ErrInvalidEncryptKey
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		aeads[keyID] = aead
+	}
+	if _, ok := aeads[currentKeyID]; !ok {
+		return nil, ErrUnknownEncryptKey
+	}
+	return &AESGCMEncryptor{currentKeyID: currentKeyID, aeads: aeads}, nil
+}
+
+func (e *AESGCMEncryptor) KeyID() uint32 {
+	return e.currentKeyID
+}
+
+// Encrypt 加密之后的格式为 nonce + 密文
+func (e *AESGCMEncryptor) Encrypt(keyID uint32, plaintext []byte) ([]byte, error) {
+	aead, ok := e.aeads[keyID]
+	if !ok {
+		return nil, ErrUnknownEncryptKey
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (e *AESGCMEncryptor) Decrypt(keyID uint32, ciphertext []byte) ([]byte, error) {
+	aead, ok := e.aeads[keyID]
+	if !ok {
+		return nil, ErrUnknownEncryptKey
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
+
+// Encrypt 使用当前的密钥加密 value，encryptKey 为 true 时同时加密 key
+// 需要在压缩之后调用
+func (lr *LogRecord) Encrypt(enc Encryptor, encryptKey bool) error {
+	if err := lr.EncryptValue(enc); err != nil {
+		return err
+	}
+	if encryptKey {
+		return lr.EncryptKey(enc)
+	}
+	return nil
+}
+
+// EncryptValue 使用当前的密钥加密 value，已经加密过的 value 不会重复加密
+// value 比较大时加密比较耗时，可以先单独加密 value，之后再加密 key
+func (lr *LogRecord) EncryptValue(enc Encryptor) error {
+	if enc == nil || lr.KeyID != 0 {
+		return nil
+	}
+	keyID := enc.KeyID()
+	if keyID == 0 {
+		return ErrInvalidEncryptKey
+	}
+	if len(lr.Value) > 0 {
+		value, err := enc.Encrypt(keyID, lr.Value)
+		if err != nil {
+			return err
+		}
+		lr.Value = value
+	}
+	lr.KeyID = keyID
+	return nil
+}
+
+// EncryptKey 使用加密 value 的密钥加密 key，需要在 EncryptValue 之后调用
+func (lr *LogRecord) EncryptKey(enc Encryptor) error {
+	if enc == nil || lr.KeyEncrypted {
+		return nil
+	}
+	if lr.KeyID == 0 {
+		return ErrInvalidEncryptKey
+	}
+	key, err := enc.Encrypt(lr.KeyID, lr.Key)
+	if err != nil {
+		return err
+	}
+	lr.Key = key
+	lr.KeyEncrypted = true
+	return nil
+}
+
+// 根据 LogRecord 中记录的密钥 id 解密 key 和 value
+func (lr *LogRecord) decrypt(enc Encryptor) error {
+	if lr.KeyID == 0 {
+		return nil
+	}
+	if enc == nil {
+		return ErrEncryptorMissing
+	}
+	if len(lr.Value) > 0 {
+		value, err := enc.Decrypt(lr.KeyID, lr.Value)
+		if err != nil {
+			return err
+		}
+		lr.Value = value
+	}
+	if lr.KeyEncrypted {
+		key, err := enc.Decrypt(lr.KeyID, lr.Key)
+		if err != nil {
+			return err
+		}
+		lr.Key = key
+		lr.KeyEncrypted = false
+	}
+	lr.KeyID = 0
+	return nil
+}
@@ -3,6 +3,9 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"io"
+	"math"
+	"time"
 )
 
 type LogRecordType = byte
@@ -11,26 +14,55 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	LogRecordRangeDeleted // 范围删除，key 是范围的起点，value 是范围的终点，终点为空表示没有上界
+	LogRecordMergeOperand // 合并操作数，读取时按照写入顺序合并到之前的值上
 )
 
-// crc type keySize valueSize
-// 4 +  1  +  5   +   5 = 15
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// header 中的标记位
+const (
+	flagKeyEncrypted byte = 1 << iota // key 被加密
+	flagBlobRef                       // value 中存储的是 blob 文件中的位置
+	flagNamespace                     // key 的前缀是 namespace id
+)
+
+// type 字节的最高位标识 header 的格式，为 1 时 header 中带有 codec、flags、过期时间、写入时间和密钥 id
+// 旧版本写入的 header 只有 crc type keySize valueSize，type 最大为 LogRecordTxnFinished，最高位总是 0
+const recordTypeExtended byte = 0x80
+
+// crc type codec flags keySize valueSize expire timestamp keyId
+// 4 +  1  +  1  +   1   +  5   +   5   +   10  +   10    +  5   = 42
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*3 + binary.MaxVarintLen64*2 + 7
 
 // LogRecord 写入到数据文件的记录
 // 之所以叫日志，是因为数据文件中的数据是追加写入的，类似日志的格式
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key       []byte
+	Value     []byte
+	Type      LogRecordType
+	Expire    int64     // 过期时间，UnixNano 时间戳，0 表示永不过期
+	Timestamp int64     // 写入时间，UnixNano 时间戳
+	Codec     CodecType // value 的压缩算法
+
+	KeyID        uint32 // 加密使用的密钥 id，0 表示没有加密
+	KeyEncrypted bool   // key 是否被加密
+
+	BlobRef bool // value 是否分离到了 blob 文件中，为 true 时 value 中存储的是 blob 位置
+
+	Namespace uint32 // 数据所属的 namespace id，0 表示默认的 namespace，编码时作为 key 的前缀
 }
 
 // LogRecord 的头部信息
 type logRecordHeader struct {
 	crc        uint32        // crc 校验值
 	recordType LogRecordType // 标识 LogRecord 的类型
+	codec      CodecType     // value 的压缩算法
+	flags      byte          // 标记位
 	keySize    uint32        // key 的长度
 	valueSize  uint32        // value 的长度
+	expire     int64         // 过期时间
+	timestamp  int64         // 写入时间
+	keyId      uint32        // 加密使用的密钥 id
+	extended   bool          // 是否带有扩展字段，旧版本写入的数据为 false
 }
 
 // LogRecordPos 数据内存索引，主要是描述数据在磁盘上的位置
@@ -38,6 +70,23 @@ type LogRecordPos struct {
 	Fid    uint32 // 文件 id，表示将数据存储到了哪个文件当中
 	Offset int64  // 偏移，表示将数据存储到了数据文件中的哪个位置
 	Size   uint32 // 标识数据在磁盘上的大小
+	Expire int64  // 过期时间，0 表示永不过期
+
+	BlobFid  uint32 // value 所在的 blob 文件 id
+	BlobSize uint32 // value 在 blob 文件中的大小，0 表示 value 没有分离到 blob 文件中
+}
+
+// IsExpired 判断数据在给定的时间点是否已经过期
+func (pos *LogRecordPos) IsExpired(now int64) bool {
+	return pos.Expire > 0 && pos.Expire <= now
+}
+
+// ExpireAt 根据 ttl 计算过期时间戳，ttl 小于等于 0 表示永不过期
+func ExpireAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
 }
 
 type TransactionRecord struct {
@@ -47,30 +96,50 @@ type TransactionRecord struct {
 
 // EncodeLogRecord 对 LogRecord 进行编码，返回字节数组及长度
 //
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	| crc 校验值  |  type 类型   |    key size |   value size |      key    |      value   |
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	    4字节          1字节        变长（最大5）   变长（最大5）     变长           变长
+//	+-------------+-------------+-------------+-------------+-------------+--------------+--------------+--------------+-------------+-------------+--------------+
+//	| crc 校验值  |  type 类型   | codec 压缩   |  flags 标记  |    key size |   value size |    expire    |   timestamp  |    key id   |      key    |      value   |
+//	+-------------+-------------+-------------+-------------+-------------+--------------+--------------+--------------+-------------+-------------+--------------+
+//	    4字节          1字节         1字节         1字节        变长（最大5）   变长（最大5）   变长（最大10）   变长（最大10）   变长（最大5）     变长           变长
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	// 初始化一个 header 部分的字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
 
-	// 第五个字节存储 Type
-	header[4] = logRecord.Type
-	var index = 5
-	// 5 字节之后，存储的是 key 和 value 的长度信息
+	// 第五个字节存储 Type，最高位标识 header 带有扩展字段
+	header[4] = logRecord.Type | recordTypeExtended
+	// 第六个字节存储 value 的压缩算法
+	header[5] = logRecord.Codec
+	// 第七个字节存储标记位
+	if logRecord.KeyEncrypted {
+		header[6] |= flagKeyEncrypted
+	}
+	if logRecord.BlobRef {
+		header[6] |= flagBlobRef
+	}
+	// 非默认的 namespace，将 namespace id 编码到 key 的前缀中
+	key := logRecord.Key
+	if logRecord.Namespace != 0 {
+		header[6] |= flagNamespace
+		key = encodeNamespaceKey(logRecord.Namespace, logRecord.Key)
+	}
+	var index = 7
+	// 7 字节之后，存储的是 key 和 value 的长度信息
 	// 使用变长类型，节省空间
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
+	index += binary.PutVarint(header[index:], int64(len(key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	// 过期时间和写入时间
+	index += binary.PutVarint(header[index:], logRecord.Expire)
+	index += binary.PutVarint(header[index:], logRecord.Timestamp)
+	// 加密使用的密钥 id
+	index += binary.PutUvarint(header[index:], uint64(logRecord.KeyID))
 
-	var size = index + len(logRecord.Key) + len(logRecord.Value)
+	var size = index + len(key) + len(logRecord.Value)
 	encBytes := make([]byte, size)
 
 	// 将 header 部分的内容拷贝过来
 	copy(encBytes[:index], header[:index])
 	// 将 key 和 value 数据拷贝到字节数组中
-	copy(encBytes[index:], logRecord.Key)
-	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
+	copy(encBytes[index:], key)
+	copy(encBytes[index+len(key):], logRecord.Value)
 
 	// 对整个 LogRecord 的数据进行 crc 校验
 	crc := crc32.ChecksumIEEE(encBytes[4:])
@@ -79,12 +148,32 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	return encBytes, int64(size)
 }
 
+// 在 key 的前面加上 namespace id
+func encodeNamespaceKey(namespace uint32, key []byte) []byte {
+	prefix := make([]byte, binary.MaxVarintLen32)
+	n := binary.PutUvarint(prefix, uint64(namespace))
+	encKey := make([]byte, n+len(key))
+	copy(encKey[:n], prefix[:n])
+	copy(encKey[n:], key)
+	return encKey
+}
+
+// 解析出 key 前缀中的 namespace id
+func decodeNamespaceKey(encKey []byte) (uint32, []byte) {
+	namespace, n := binary.Uvarint(encKey)
+	return uint32(namespace), encKey[n:]
+}
+
 // EncodeLogRecordPos 对位置信息进行编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*4+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutVarint(buf[index:], pos.Expire)
+	index += binary.PutVarint(buf[index:], int64(pos.BlobFid))
+	index += binary.PutVarint(buf[index:], int64(pos.BlobSize))
+	index += binary.PutVarint(buf[index:], int64(pos.Size))
 	return buf[:index]
 }
 
@@ -93,14 +182,41 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	var index = 0
 	fileId, n := binary.Varint(buf[index:])
 	index += n
-	offset, _ := binary.Varint(buf[index:])
-	return &LogRecordPos{Fid: uint32(fileId), Offset: offset}
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	expire, n := binary.Varint(buf[index:])
+	index += n
+	// 旧版本编码的位置信息中没有 blob 信息，解码得到的是 0
+	blobFid, n := binary.Varint(buf[index:])
+	index += n
+	blobSize, n := binary.Varint(buf[index:])
+	index += n
+	// 旧版本编码的位置信息中没有数据大小，解码得到的是 0
+	size, _ := binary.Varint(buf[index:])
+	return &LogRecordPos{
+		Fid:      uint32(fileId),
+		Offset:   offset,
+		Size:     uint32(size),
+		Expire:   expire,
+		BlobFid:  uint32(blobFid),
+		BlobSize: uint32(blobSize),
+	}
 }
 
-// 对字节数组中的 Header 信息进行解码
-func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
+// header 中的类型和标记位是否都是已知的
+func (h *logRecordHeader) isValid() bool {
+	if !h.extended {
+		return h.recordType <= LogRecordTxnFinished
+	}
+	const knownFlags = flagKeyEncrypted | flagBlobRef | flagNamespace
+	return h.recordType <= LogRecordMergeOperand && h.flags&^knownFlags == 0
+}
+
+// 对字节数组中的 Header 信息进行解码，兼容旧版本写入的不带扩展字段的 header
+// buf 中没有 header 时返回 io.EOF，header 不完整时返回 ErrIncompleteLogRecord，无法解码时返回 ErrInvalidCRC
+func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64, error) {
 	if len(buf) <= 4 {
-		return nil, 0
+		return nil, 0, io.EOF
 	}
 
 	header := &logRecordHeader{
@@ -109,17 +225,68 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	}
 
 	var index = 5
-	// 取出实际的 key size
-	keySize, n := binary.Varint(buf[index:])
-	header.keySize = uint32(keySize)
-	index += n
+	if buf[4]&recordTypeExtended != 0 {
+		if len(buf) <= 6 {
+			return nil, 0, ErrIncompleteLogRecord
+		}
+		header.recordType = buf[4] &^ recordTypeExtended
+		header.codec = buf[5]
+		header.flags = buf[6]
+		header.extended = true
+		index = 7
+	}
 
-	// 取出实际的 value size
-	valueSize, n := binary.Varint(buf[index:])
-	header.valueSize = uint32(valueSize)
+	// 依次取出变长的字段，buf 在字段中间结束说明 header 不完整
+	var err error
+	readVarint := func() int64 {
+		if err != nil {
+			return 0
+		}
+		v, n := binary.Varint(buf[index:])
+		if n == 0 {
+			err = ErrIncompleteLogRecord
+		} else if n < 0 {
+			err = ErrInvalidCRC
+		}
+		index += n
+		return v
+	}
+
+	// 取出实际的 key size 和 value size
+	keySize := readVarint()
+	valueSize := readVarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	if keySize < 0 || valueSize < 0 || keySize > math.MaxUint32 || valueSize > math.MaxUint32 {
+		return nil, 0, ErrInvalidCRC
+	}
+	header.keySize, header.valueSize = uint32(keySize), uint32(valueSize)
+
+	// 旧版本的 header 到此结束
+	if !header.extended {
+		return header, int64(index), nil
+	}
+
+	// 取出过期时间和写入时间
+	header.expire = readVarint()
+	header.timestamp = readVarint()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 取出密钥 id
+	keyId, n := binary.Uvarint(buf[index:])
+	if n == 0 {
+		return nil, 0, ErrIncompleteLogRecord
+	}
+	if n < 0 || keyId > math.MaxUint32 {
+		return nil, 0, ErrInvalidCRC
+	}
+	header.keyId = uint32(keyId)
 	index += n
 
-	return header, int64(index)
+	return header, int64(index), nil
 }
 
 func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
@@ -1,7 +1,9 @@
 package data
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"testing"
 )
 
@@ -25,5 +27,153 @@ func TestEncodeLogRecord(t *testing.T) {
 }
 
 func TestDecoderLogRecordHeader(t *testing.T) {
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go"), Expire: 1686000000000000000}
+	encRec, _ := EncodeLogRecord(rec)
+	header, headerSize, err := decodeLogRecordHeader(encRec)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4), header.keySize)
+	assert.Equal(t, uint32(10), header.valueSize)
+	assert.Equal(t, rec.Expire, header.expire)
 
+	// 在变长字段中间结束的 header
+	_, _, err = decodeLogRecordHeader(encRec[:headerSize-1])
+	assert.Equal(t, ErrIncompleteLogRecord, err)
+
+	// 无法解码的变长字段
+	_, _, err = decodeLogRecordHeader(bytes.Repeat([]byte{0xff}, 64))
+	assert.Equal(t, ErrInvalidCRC, err)
+
+	_, _, err = decodeLogRecordHeader([]byte{0xff, 0xff})
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEncodeLogRecordPos(t *testing.T) {
+	pos := &LogRecordPos{Fid: 12, Offset: 1024, Expire: 1686000000000000000}
+	res := DecodeLogRecordPos(EncodeLogRecordPos(pos))
+	assert.Equal(t, pos.Fid, res.Fid)
+	assert.Equal(t, pos.Offset, res.Offset)
+	assert.Equal(t, pos.Expire, res.Expire)
+
+	assert.False(t, res.IsExpired(pos.Expire-1))
+	assert.True(t, res.IsExpired(pos.Expire))
+	assert.False(t, (&LogRecordPos{}).IsExpired(pos.Expire))
+
+	pos = &LogRecordPos{Fid: 3, Offset: 100, Size: 58, BlobFid: 7, BlobSize: 4 << 20}
+	res = DecodeLogRecordPos(EncodeLogRecordPos(pos))
+	assert.Equal(t, pos.Size, res.Size)
+	assert.Equal(t, pos.BlobFid, res.BlobFid)
+	assert.Equal(t, pos.BlobSize, res.BlobSize)
+}
+
+type reverseCodec struct{}
+
+func (reverseCodec) Type() CodecType {
+	return 100
+}
+
+func (reverseCodec) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, len(src)/2)
+	for i := range dst {
+		dst[i] = src[len(src)-1-i]
+	}
+	return dst, nil
+}
+
+func (reverseCodec) Decompress(src []byte) ([]byte, error) {
+	dst := make([]byte, len(src)*2)
+	for i := range src {
+		dst[len(dst)-1-i] = src[i]
+		dst[i] = src[i]
+	}
+	return dst, nil
+}
+
+func TestLogRecord_CompressValue(t *testing.T) {
+	value := bytes.Repeat([]byte("bitcask-go"), 100)
+	for _, typ := range []CodecType{CodecFlate, CodecGzip} {
+		rec := &LogRecord{Key: []byte("name"), Value: value}
+		err := rec.CompressValue(typ)
+		assert.Nil(t, err)
+		assert.Equal(t, typ, rec.Codec)
+		assert.Less(t, len(rec.Value), len(value))
+
+		err = rec.decompressValue()
+		assert.Nil(t, err)
+		assert.Equal(t, CodecNone, rec.Codec)
+		assert.Equal(t, value, rec.Value)
+	}
+
+	// 压缩之后没有变小，保留原始数据
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("a")}
+	err := rec.CompressValue(CodecGzip)
+	assert.Nil(t, err)
+	assert.Equal(t, CodecNone, rec.Codec)
+	assert.Equal(t, []byte("a"), rec.Value)
+
+	// 未注册的压缩算法
+	err = (&LogRecord{Value: value}).CompressValue(100)
+	assert.Equal(t, ErrUnknownCodec, err)
+
+	// 自定义的压缩算法
+	RegisterCodec(reverseCodec{})
+	value = []byte("abccba")
+	rec = &LogRecord{Value: value}
+	err = rec.CompressValue(100)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), rec.Value)
+	err = rec.decompressValue()
+	assert.Nil(t, err)
+	assert.Equal(t, value, rec.Value)
+}
+
+func TestLogRecord_Encrypt(t *testing.T) {
+	enc1, err := NewAESGCMEncryptor(1, map[uint32][]byte{1: bytes.Repeat([]byte("k"), 32)})
+	assert.Nil(t, err)
+	enc2, err := NewAESGCMEncryptor(2, map[uint32][]byte{
+		1: bytes.Repeat([]byte("k"), 32),
+		2: bytes.Repeat([]byte("n"), 16),
+	})
+	assert.Nil(t, err)
+
+	key, value := []byte("name"), []byte("bitcask-go")
+	rec := &LogRecord{Key: key, Value: value}
+	err = rec.Encrypt(enc1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), rec.KeyID)
+	assert.True(t, rec.KeyEncrypted)
+	assert.NotEqual(t, key, rec.Key)
+	assert.NotEqual(t, value, rec.Value)
+
+	// 编解码之后使用轮换之后的密钥解密
+	encRec, _ := EncodeLogRecord(rec)
+	header, headerSize, err := decodeLogRecordHeader(encRec)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), header.keyId)
+	assert.Equal(t, flagKeyEncrypted, header.flags)
+	dec := &LogRecord{
+		Key:          encRec[headerSize : headerSize+int64(header.keySize)],
+		Value:        encRec[headerSize+int64(header.keySize):],
+		KeyID:        header.keyId,
+		KeyEncrypted: header.flags&flagKeyEncrypted != 0,
+	}
+	err = dec.decrypt(enc2)
+	assert.Nil(t, err)
+	assert.Equal(t, key, dec.Key)
+	assert.Equal(t, value, dec.Value)
+	assert.Equal(t, uint32(0), dec.KeyID)
+
+	// 没有加密或者密钥不存在
+	rec = &LogRecord{Key: key, Value: value}
+	_ = rec.Encrypt(enc2, false)
+	assert.Equal(t, key, rec.Key)
+	assert.Equal(t, ErrEncryptorMissing, rec.decrypt(nil))
+	assert.Equal(t, ErrUnknownEncryptKey, rec.decrypt(enc1))
+
+	// 非法的密钥
+	_, err = NewAESGCMEncryptor(0, map[uint32][]byte{0: bytes.Repeat([]byte("k"), 32)})
+	assert.Equal(t, ErrInvalidEncryptKey, err)
+	_, err = NewAESGCMEncryptor(3, map[uint32][]byte{1: bytes.Repeat([]byte("k"), 32)})
+	assert.Equal(t, ErrUnknownEncryptKey, err)
+	_, err = NewAESGCMEncryptor(1, map[uint32][]byte{1: []byte("short")})
+	assert.NotNil(t, err)
 }
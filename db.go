@@ -7,20 +7,21 @@ import (
 	"bitcask-go/utils"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofrs/flock"
 )
 
 const (
-	seqNoKey     = "seq.no"
-	fileLockName = "flock"
+	seqNoKey           = "seq.no"
+	fileLockName       = "flock"
+	writerFileLockName = "flock.writer"
 )
 
 // DB bitcask 存储引擎实例
@@ -35,9 +36,41 @@ type DB struct {
 	isMerging       bool                      // 是否正在 merge
 	seqNoFileExists bool                      // 存储事务序列号的文件是否存在
 	isInitial       bool                      // 是否是第一次初始化此数据目录
-	fileLock        *flock.Flock              // 文件锁保证多进程之间的互斥
+	fileLock        *flock.Flock              // 文件锁保证多进程之间的互斥，读写实例和只读实例都持有共享锁
+	writerLock      *flock.Flock              // 读写实例持有的排他锁，同一时间只有一个读写实例
 	bytesWrite      uint                      // 累计写了多少个字节
 	reclaimSize     int64                     // 表示有多少数据是无效的
+	fileReclaimSize map[uint32]int64          // 每个数据文件中无效的数据量
+	hintFileIds     map[uint32]struct{}       // 拥有独立 hint 文件的数据文件 id，只能在加载索引的时候使用
+	activeTxns      map[*Txn]struct{}         // 当前正在进行中的事务
+	watch           *watchState               // 数据变更订阅
+
+	activeBlobFile    *data.DataFile            // 当前活跃 blob 文件，存储分离出来的大 value
+	olderBlobFiles    map[uint32]*data.DataFile // 旧的 blob 文件
+	blobReclaimSize   map[uint32]int64          // 每个 blob 文件中无效的数据量
+	obsoleteBlobFiles map[uint32]struct{}       // 已经回收的 blob 文件，下一次启动时删除
+
+	namespaces   map[uint32]*Namespace // 所有的 namespace，不包含默认的 namespace
+	namespaceIds map[string]uint32     // namespace 名称和 id 的对应关系
+
+	autoMerger *autoMerger // 后台自动 merge，没有启动时为 nil
+	epochs     *fileEpochs // 数据文件的版本，merge 替换下来的数据文件在读取者结束之后删除
+
+	recovery RecoveryReport   // 启动时按照恢复策略丢弃的数据
+	metrics  *dbMetrics       // 运行指标
+	events   *eventDispatcher // 内部事件的投递，没有配置监听者时为 nil
+
+	bgLimiter *utils.RateLimiter // merge 和备份的 IO 限速
+
+	fileHints  bool            // 是否在写入数据时为每个数据文件生成 hint 文件
+	activeHint *fileHintWriter // 活跃文件的 hint 文件，没有生成时为 nil
+	lastFileId uint32          // 活跃文件 id 的上限，写满之后不再转换，为 0 表示没有上限，只在 merge 的临时实例中使用
+
+	operands map[operandPos]*operandNode // 合并操作数记录的前一个记录位置，读取时据此找到原来的值
+
+	commits     *commitQueue // 需要持久化的写入的组提交队列
+	deferSync   bool         // 组提交期间写入只做持久化的标记
+	syncPending bool         // 组提交期间是否有需要持久化的写入
 }
 
 // Stat 存储引擎统计信息
@@ -46,10 +79,19 @@ type Stat struct {
 	DataFileNum     uint  // 数据文件的数量
 	ReclaimableSize int64 // 可以进行 merge 回收的数据量，字节为单位
 	DiskSize        int64 // 数据目录所占磁盘空间大小
+
+	FileReclaimableSize map[uint32]int64 // 每个数据文件中可以回收的数据量，key 为文件 id
+
+	BlobFileNum         uint  // blob 文件的数量
+	BlobReclaimableSize int64 // blob 文件中可以回收的数据量，字节为单位
+
+	AutoMerge AutoMergeStat // 后台自动 merge 的状态
+
+	DroppedEvents uint64 // 监听者处理过慢时丢弃的事件数量
 }
 
 // Open 打开 bitcask 存储引擎实例
-func Open(options Options) (*DB, error) {
+func Open(options Options) (_ *DB, err error) {
 	// 对用户传入的配置项进行校验
 	if err := checkOptions(options); err != nil {
 		return nil, err
@@ -58,6 +100,10 @@ func Open(options Options) (*DB, error) {
 	var isInitial bool
 	// 判断数据目录是否存在，如果不存在的话，则创建这个目录
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		// 只读模式下不能创建数据目录
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
@@ -65,14 +111,16 @@ func Open(options Options) (*DB, error) {
 	}
 
 	// 判断当前数据目录是否正在使用
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
+	fileLock, writerLock, err := lockDirectory(options)
 	if err != nil {
 		return nil, err
 	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
-	}
+	// 打开失败时释放文件锁
+	defer func() {
+		if err != nil {
+			_ = unlockDirectory(fileLock, writerLock)
+		}
+	}()
 
 	entries, err := os.ReadDir(options.DirPath)
 	if err != nil {
@@ -82,18 +130,71 @@ func Open(options Options) (*DB, error) {
 		isInitial = true
 	}
 
+	// 只读模式下以只读方式打开 B+ 树索引，索引文件不存在时打开失败
+	var idx index.Indexer
+	if options.ReadOnly && options.IndexType == BPlusTree {
+		bptree, err := index.NewReadOnlyBPlusTree(options.DirPath)
+		if err != nil {
+			return nil, err
+		}
+		idx = bptree
+	} else {
+		idx = index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites)
+	}
+
 	// 初始化 DB 实例结构体
 	db := &DB{
 		options:    options,
 		mu:         new(sync.RWMutex),
 		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
+		activeTxns: make(map[*Txn]struct{}),
+		watch:      newWatchState(),
+		index:      idx,
 		isInitial:  isInitial,
 		fileLock:   fileLock,
+		writerLock: writerLock,
+
+		fileReclaimSize: make(map[uint32]int64),
+		hintFileIds:     make(map[uint32]struct{}),
+
+		olderBlobFiles:    make(map[uint32]*data.DataFile),
+		blobReclaimSize:   make(map[uint32]int64),
+		obsoleteBlobFiles: make(map[uint32]struct{}),
+
+		namespaces:   make(map[uint32]*Namespace),
+		namespaceIds: make(map[string]uint32),
+
+		operands: make(map[operandPos]*operandNode),
+		epochs:   newFileEpochs(options.DirPath),
+		commits:  newCommitQueue(),
+
+		bgLimiter: utils.NewRateLimiter(options.BackgroundIOBytesPerSec),
+		// B+ 树索引启动时不需要加载数据文件
+		fileHints: !options.ReadOnly && options.IndexType != BPlusTree,
+	}
+	db.metrics = newDBMetrics(db)
+	db.events = newEventDispatcher(options.EventListener, options.EventBufferSize)
+	// 打开失败时关闭已经打开的数据文件和索引
+	defer func() {
+		if err != nil {
+			_ = db.closeDataFiles()
+			_ = db.closeIndexes()
+			db.events.close()
+		}
+	}()
+
+	// 加载选择性 merge 和 merge 数据目录，只读模式下不能移动和删除文件
+	if !options.ReadOnly {
+		if err := db.loadCompactFiles(); err != nil {
+			return nil, err
+		}
+		if err := db.loadMergeFiles(); err != nil {
+			return nil, err
+		}
 	}
 
-	// 加载 merge 数据目录
-	if err := db.loadMergeFiles(); err != nil {
+	// 加载 namespace
+	if err := db.loadNamespaces(); err != nil {
 		return nil, err
 	}
 
@@ -102,7 +203,13 @@ func Open(options Options) (*DB, error) {
 		return nil, err
 	}
 
+	// 加载 blob 文件
+	if err := db.loadBlobFiles(); err != nil {
+		return nil, err
+	}
+
 	// B+树索引不需要从数据文件中加载索引
+	loadStart := time.Now()
 	if options.IndexType != BPlusTree {
 		// 从 hint 索引文件中加载索引
 		if err := db.loadIndexFromHintFile(); err != nil {
@@ -114,14 +221,16 @@ func Open(options Options) (*DB, error) {
 			return nil, err
 		}
 
-		// 重置 IO 类型为标准文件 IO
-		if db.options.MMapAtStartup {
+		// 重置 IO 类型为标准文件 IO，只读模式下不需要写入，可以继续使用 MMap
+		if db.options.MMapAtStartup && !db.options.ReadOnly {
 			if err := db.resetIoType(); err != nil {
 				return nil, err
 			}
 		}
 	}
 
+	db.metrics.indexLoadSeconds.Set(time.Since(loadStart).Seconds())
+
 	// 取出当前事务序列号
 	if options.IndexType == BPlusTree {
 		if err := db.loadSeqNo(); err != nil {
@@ -136,16 +245,37 @@ func Open(options Options) (*DB, error) {
 		}
 	}
 
+	// 统计 blob 文件中的无效数据量
+	db.loadBlobReclaimSize()
+
+	if db.recovery.DiscardedBytes > 0 {
+		report := db.RecoveryReport()
+		db.events.post(func(l EventListener) {
+			l.OnRecovery(report)
+		})
+	}
+
+	// 启动后台自动 merge
+	db.startAutoMerge()
+
 	return db, nil
 }
 
 // Close 关闭数据库
 func (db *DB) Close() error {
 	defer func() {
-		if err := db.fileLock.Unlock(); err != nil {
+		if err := unlockDirectory(db.fileLock, db.writerLock); err != nil {
 			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
 		}
 	}()
+	// 等待已经产生的事件投递完成
+	defer db.events.close()
+	// 停止后台自动 merge
+	if db.autoMerger != nil {
+		db.autoMerger.stop()
+	}
+	// 关闭所有的订阅
+	db.watch.close()
 	if db.activeFile == nil {
 		return nil
 	}
@@ -153,10 +283,18 @@ func (db *DB) Close() error {
 	defer db.mu.Unlock()
 
 	// 关闭索引
-	if err := db.index.Close(); err != nil {
+	if err := db.closeIndexes(); err != nil {
 		return err
 	}
 
+	// 只读模式下不需要保存事务序列号
+	if db.options.ReadOnly {
+		return db.closeDataFiles()
+	}
+
+	// 活跃文件的 hint 文件覆盖到当前写入的位置，下一次启动时只需要扫描之后写入的数据
+	db.finishActiveHint()
+
 	// 保存当前事务序列号
 	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
 	if err != nil {
@@ -173,28 +311,65 @@ func (db *DB) Close() error {
 	if err := seqNoFile.Sync(); err != nil {
 		return err
 	}
+	return db.closeDataFiles()
+}
 
-	//	关闭当前活跃文件
-	if err := db.activeFile.Close(); err != nil {
+// 关闭默认的索引以及所有 namespace 的索引
+func (db *DB) closeIndexes() error {
+	if err := db.index.Close(); err != nil {
 		return err
 	}
+	for _, ns := range db.namespaces {
+		if err := ns.index.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 关闭所有的数据文件和 blob 文件
+func (db *DB) closeDataFiles() error {
+	// 关闭并删除 merge 替换下来的数据文件
+	db.epochs.close()
+	// 没有完成的 hint 文件在加载时不会被使用
+	if db.activeHint != nil {
+		_ = db.activeHint.file.Close()
+		db.activeHint = nil
+	}
+	//	关闭当前活跃文件
+	if db.activeFile != nil {
+		if err := db.activeFile.Close(); err != nil {
+			return err
+		}
+	}
 	// 关闭旧的数据文件
 	for _, file := range db.olderFiles {
 		if err := file.Close(); err != nil {
 			return err
 		}
 	}
+	// 关闭 blob 文件
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Close(); err != nil {
+			return err
+		}
+	}
+	for _, file := range db.olderBlobFiles {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // Sync 持久化数据文件
 func (db *DB) Sync() error {
-	if db.activeFile == nil {
+	if db.activeFile == nil || db.options.ReadOnly {
 		return nil
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.activeFile.Sync()
+	return db.syncActiveFiles()
 }
 
 // Stat 返回数据库的相关统计信息
@@ -206,61 +381,209 @@ func (db *DB) Stat() *Stat {
 	if db.activeFile != nil {
 		dataFiles += 1
 	}
+	var blobFiles = uint(len(db.olderBlobFiles))
+	if db.activeBlobFile != nil {
+		blobFiles += 1
+	}
+	var blobReclaimSize int64
+	for _, size := range db.blobReclaimSize {
+		blobReclaimSize += size
+	}
 
+	// 获取数据目录大小失败时为 -1
 	dirSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
-		panic(fmt.Sprintf("failed to get dir size : %v", err))
+		dirSize = -1
 	}
-	return &Stat{
+	fileReclaimSize := make(map[uint32]int64, len(db.fileReclaimSize))
+	for fid, size := range db.fileReclaimSize {
+		fileReclaimSize[fid] = size
+	}
+	stat := &Stat{
 		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFiles,
 		ReclaimableSize: db.reclaimSize,
 		DiskSize:        dirSize,
+
+		FileReclaimableSize: fileReclaimSize,
+
+		BlobFileNum:         blobFiles,
+		BlobReclaimableSize: blobReclaimSize,
+
+		DroppedEvents: db.events.droppedEvents(),
+	}
+	if db.autoMerger != nil {
+		stat.AutoMerge = db.autoMerger.getStat()
 	}
+	return stat
 }
 
 // Backup 备份数据库，将数据文件拷贝到新的目录
+// 只在记录数据文件大小时持有读锁，数据文件和 blob 文件在释放锁之后按照后台 IO 的速率限制拷贝
 func (db *DB) Backup(dir string) error {
 	db.mu.RLock()
-	defer db.mu.RUnlock()
-	return utils.CoypDir(db.options.DirPath, dir, []string{fileLockName})
+	// 持有版本，拷贝期间 merge 替换下来的数据文件不会被关闭和删除
+	epoch := db.epochs.acquire()
+	defer db.epochs.release(epoch)
+	files, err := db.snapshotDir(dir)
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// 数据文件只会追加写入，拷贝记录时的大小即可得到一致的备份
+	for _, file := range files {
+		dest := filepath.Join(dir, file.name)
+		if file.dataFile != nil {
+			// 选择性 merge 会原地替换数据文件，从记录时打开的数据文件中拷贝
+			err = utils.CopyFrom(file.dataFile, dest, file.size, fio.DataFilePerm, db.bgLimiter)
+		} else {
+			err = utils.CopyFile(filepath.Join(db.options.DirPath, file.name), dest, file.size, db.bgLimiter)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 备份时需要拷贝的数据文件和 blob 文件
+type backupFile struct {
+	name     string
+	size     int64
+	dataFile *data.DataFile // 数据文件从打开的文件中拷贝，为 nil 表示按照文件名拷贝
+}
+
+// 拷贝数据目录中除数据文件和 blob 文件之外的文件，返回数据文件和 blob 文件当前的大小
+// 在访问此方法前必须持有互斥锁
+func (db *DB) snapshotDir(dir string) ([]*backupFile, error) {
+	exclude := []string{fileLockName, writerFileLockName, "*" + data.DataFileNameSuffix, "*" + data.BlobFileNameSuffix}
+	if err := utils.CoypDir(db.options.DirPath, dir, exclude); err != nil {
+		return nil, err
+	}
+	var files []*backupFile
+	dataFiles := make([]*data.DataFile, 0, len(db.olderFiles)+1)
+	for _, dataFile := range db.olderFiles {
+		dataFiles = append(dataFiles, dataFile)
+	}
+	if db.activeFile != nil {
+		dataFiles = append(dataFiles, db.activeFile)
+	}
+	for _, dataFile := range dataFiles {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(data.GetDataFileName(db.options.DirPath, dataFile.FileId))
+		files = append(files, &backupFile{name: name, size: size, dataFile: dataFile})
+	}
+
+	entries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, data.BlobFileNameSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &backupFile{name: name, size: info.Size()})
+	}
+	return files, nil
+}
+
+// SetBackgroundRateLimit 调整 merge 和备份每秒读写的字节数，小于等于 0 表示不限速
+// 正在执行的 merge 和备份按照新的速率继续执行
+func (db *DB) SetBackgroundRateLimit(bytesPerSec int64) {
+	db.bgLimiter.SetRate(bytesPerSec)
 }
 
 // Put 写入 Key/Value 数据，key 不能为空
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL 写入带有过期时间的 Key/Value 数据，ttl 小于等于 0 表示永不过期
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	return db.PutWithOptions(key, value, WriteOptions{Sync: db.options.SyncWrites, TTL: ttl})
+}
+
+// PutWithOptions 按照单次写入的配置项写入 Key/Value 数据，是否持久化由 opts.Sync 决定
+func (db *DB) PutWithOptions(key []byte, value []byte, opts WriteOptions) error {
 	// 判断 key 是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
+
+	defer db.metrics.observe(db.metrics.puts, db.metrics.putLatency, time.Now())
+	// 压缩和加密不需要持有互斥锁
+	encoded, err := db.encodeValue(value)
+	if err != nil {
+		return err
+	}
+	return db.commit(opts.Sync, func() error {
+		return db.put(key, value, encoded, data.ExpireAt(opts.TTL), opts.Sync)
+	})
+}
+
+// Delete 根据 key 删除对应的数据
+func (db *DB) Delete(key []byte) error {
+	return db.DeleteWithOptions(key, WriteOptions{Sync: db.options.SyncWrites})
+}
+
+// DeleteWithOptions 按照单次写入的配置项删除数据，是否持久化由 opts.Sync 决定
+func (db *DB) DeleteWithOptions(key []byte, opts WriteOptions) error {
+	// 判断 key 的有效性
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
+
+	defer db.metrics.observe(db.metrics.deletes, db.metrics.deleteLatency, time.Now())
+	return db.commit(opts.Sync, func() error {
+		return db.delete(key, opts.Sync)
+	})
+}
 
+// 写入数据并更新内存索引，encoded 是提前压缩和加密过的 value，为 nil 时在持有锁时处理
+// 在访问此方法前必须持有互斥锁
+func (db *DB) put(key []byte, value []byte, encoded *encodedValue, expire int64, sync bool) error {
 	// 构造 LogRecord 结构体
 	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
 	}
 
 	// 追加写入到当前活跃数据文件当中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendEncodedRecord(logRecord, encoded, sync)
 	if err != nil {
 		return err
 	}
 
 	// 更新内存索引
+	db.saveTxnSnapshot(defaultNamespaceId, key)
 	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+		db.addReclaimSize(oldPos)
 	}
+	db.addWatchEvent(WatchEventPut, key, value, nonTransactionSeqNo)
 
 	return nil
 }
 
-// Delete 根据 key 删除对应的数据
-func (db *DB) Delete(key []byte) error {
-	// 判断 key 的有效性
-	if len(key) == 0 {
-		return ErrKeyIsEmpty
-	}
-
+// 删除数据并更新内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) delete(key []byte, sync bool) error {
 	// 先检查 key 是否存在，如果不存在的话直接返回
 	if pos := db.index.Get(key); pos == nil {
 		return nil
@@ -272,25 +595,33 @@ func (db *DB) Delete(key []byte) error {
 		Type: data.LogRecordDeleted,
 	}
 	// 写入到数据文件当中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord, sync)
 	if err != nil {
-		return nil
+		return err
 	}
-	db.reclaimSize += int64(pos.Size)
+	db.addReclaimSize(pos)
 
 	//	从内存索引中将对应的 key 删除
+	db.saveTxnSnapshot(defaultNamespaceId, key)
 	oldPos, ok := db.index.Delete(key)
 	if !ok {
 		return ErrIndexUpdateFailed
 	}
 	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+		db.addReclaimSize(oldPos)
 	}
+	db.addWatchEvent(WatchEventDelete, key, nil, nonTransactionSeqNo)
 	return nil
 }
 
 // Get 根据 key 读取数据
 func (db *DB) Get(key []byte) ([]byte, error) {
+	return db.GetWithOptions(key, DefaultReadOptions)
+}
+
+// GetWithOptions 按照单次读取的配置项读取数据
+func (db *DB) GetWithOptions(key []byte, opts ReadOptions) ([]byte, error) {
+	defer db.metrics.observe(db.metrics.gets, db.metrics.getLatency, time.Now())
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -298,27 +629,80 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	return db.get(key, opts)
+}
+
+// MultiGet 批量读取数据，返回的 value 和错误与 keys 一一对应
+// 所有的索引信息在一次加锁中取出，并按照文件 id 和偏移排序之后依次读取，减少随机读
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	type keyPos struct {
+		i   int
+		pos *data.LogRecordPos
+	}
+	positions := make([]keyPos, 0, len(keys))
+	now := time.Now().UnixNano()
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		logRecordPos := db.index.Get(key)
+		if logRecordPos == nil || logRecordPos.IsExpired(now) {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		positions = append(positions, keyPos{i: i, pos: logRecordPos})
+	}
 
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].pos.Fid != positions[j].pos.Fid {
+			return positions[i].pos.Fid < positions[j].pos.Fid
+		}
+		return positions[i].pos.Offset < positions[j].pos.Offset
+	})
+	for _, kp := range positions {
+		values[kp.i], errs[kp.i] = db.getValueByPosition(kp.pos, DefaultReadOptions)
+	}
+	return values, errs
+}
+
+// 根据 key 读取数据
+// 在访问此方法前必须持有读锁或者互斥锁
+func (db *DB) get(key []byte, opts ReadOptions) ([]byte, error) {
 	// 从内存数据结构中取出 key 对应的索引信息
 	logRecordPos := db.index.Get(key)
-	// 如果 key 不在内存索引中，说明 key 不存在
-	if logRecordPos == nil {
+	// 如果 key 不在内存索引中或者已经过期，说明 key 不存在
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
 		return nil, ErrKeyNotFound
 	}
 
 	// 从数据文件中获取 value
-	return db.getValueByPosition(logRecordPos)
+	return db.getValueByPosition(logRecordPos, opts)
 }
 
 // ListKeys 获取数据库中所有的 key
 func (db *DB) ListKeys() [][]byte {
-	iterator := db.index.Iterator(false)
+	return listKeys(db.index)
+}
+
+// 获取索引中所有没有过期的 key
+func listKeys(idx index.Indexer) [][]byte {
+	iterator := idx.Iterator(false)
 	defer iterator.Close()
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	keys := make([][]byte, 0, idx.Size())
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		// 跳过已经过期的 key
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
@@ -327,11 +711,21 @@ func (db *DB) ListKeys() [][]byte {
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	return db.fold(db.index, fn)
+}
 
-	iterator := db.index.Iterator(false)
+// 遍历索引中所有没有过期的数据
+// 在访问此方法前必须持有读锁
+func (db *DB) fold(idx index.Indexer, fn func(key []byte, value []byte) bool) error {
+	iterator := idx.Iterator(false)
 	defer iterator.Close()
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		value, err := db.getValueByPosition(iterator.Value())
+		// 跳过已经过期的 key
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		value, err := db.getValueByPosition(iterator.Value(), DefaultReadOptions)
 		if err != nil {
 			return err
 		}
@@ -342,14 +736,50 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	return nil
 }
 
-// 根据索引信息获取对应的 value
-func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+// 根据索引信息获取对应的 value，value 分离到 blob 文件中时从 blob 文件中读取
+func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos, opts ReadOptions) ([]byte, error) {
+	return db.getValueAt(logRecordPos, latestEpoch, opts)
+}
+
+// 持有指定版本的读取者根据索引信息获取对应的 value
+func (db *DB) getValueAt(logRecordPos *data.LogRecordPos, epoch uint64, opts ReadOptions) ([]byte, error) {
+	logRecord, err := db.readLogRecordAt(logRecordPos, epoch, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+	if logRecord.Type == data.LogRecordMergeOperand {
+		return db.readMergedValue(logRecordPos, logRecord, epoch, opts)
+	}
+	if logRecord.BlobRef {
+		return db.readBlobValue(data.DecodeBlobPos(logRecord.Value), opts)
+	}
+
+	return logRecord.Value, nil
+}
+
+// 根据索引信息读取数据文件中的 LogRecord
+func (db *DB) readLogRecordByPosition(logRecordPos *data.LogRecordPos, opts ReadOptions) (*data.LogRecord, error) {
+	return db.readLogRecordAt(logRecordPos, latestEpoch, opts)
+}
+
+// 持有指定版本的读取者根据索引信息读取数据文件中的 LogRecord
+// 索引信息是在这个版本中获取的，数据文件在这之后被 merge 替换下来时读取替换之前的文件
+func (db *DB) readLogRecordAt(logRecordPos *data.LogRecordPos, epoch uint64, opts ReadOptions) (*data.LogRecord, error) {
 	// 根据文件 id 找到对应的数据文件
 	var dataFile *data.DataFile
-	if db.activeFile.FileId == logRecordPos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.olderFiles[logRecordPos.Fid]
+	if epoch != latestEpoch {
+		dataFile = db.epochs.get(logRecordPos.Fid, epoch)
+	}
+	if dataFile == nil {
+		if db.activeFile.FileId == logRecordPos.Fid {
+			dataFile = db.activeFile
+		} else {
+			dataFile = db.olderFiles[logRecordPos.Fid]
+		}
 	}
 	// 数据文件为空
 	if dataFile == nil {
@@ -357,26 +787,42 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	}
 
 	// 根据偏移读取对应的数据
-	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+	readLogRecord := dataFile.ReadLogRecord
+	if !opts.VerifyChecksum {
+		readLogRecord = dataFile.ReadLogRecordWithoutChecksum
+	}
+	logRecord, size, err := readLogRecord(logRecordPos.Offset)
 	if err != nil {
 		return nil, err
 	}
+	db.metrics.bytesRead.Add(uint64(size))
+	return logRecord, nil
+}
 
-	if logRecord.Type == data.LogRecordDeleted {
-		return nil, ErrKeyNotFound
-	}
+// 追加写数据到活跃文件中，sync 表示写入之后是否立即持久化
+func (db *DB) appendLogRecord(logRecord *data.LogRecord, sync bool) (*data.LogRecordPos, error) {
+	return db.appendEncodedRecord(logRecord, nil, sync)
+}
 
-	return logRecord.Value, nil
+// 追加写重写的数据到活跃文件中，保留原始的写入时间，没有写入时间的旧版本数据依然为 0
+// 在访问此方法前必须持有互斥锁
+func (db *DB) appendRewrittenRecord(logRecord *data.LogRecord, sync bool) (*data.LogRecordPos, error) {
+	return db.writeLogRecord(logRecord, nil, sync)
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+// 追加写数据到活跃文件中，encoded 是在持有互斥锁之前压缩和加密过的 value，为 nil 时在这里压缩和加密
+// 在访问此方法前必须持有互斥锁
+func (db *DB) appendEncodedRecord(logRecord *data.LogRecord, encoded *encodedValue, sync bool) (*data.LogRecordPos, error) {
+	// 记录用户写入的时间
+	if logRecord.Timestamp == 0 {
+		logRecord.Timestamp = time.Now().UnixNano()
+	}
+	return db.writeLogRecord(logRecord, encoded, sync)
 }
 
-// 追加写数据到活跃文件中
-func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+// 追加写数据到活跃文件中，不修改写入时间
+// 在访问此方法前必须持有互斥锁
+func (db *DB) writeLogRecord(logRecord *data.LogRecord, encoded *encodedValue, sync bool) (*data.LogRecordPos, error) {
 	// 判断当前活跃数据文件是否存在，因为数据库在没有写入的时候是没有文件生成的
 	// 如果为空则初始化数据文件
 	if db.activeFile == nil {
@@ -385,37 +831,75 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
-	// 写入数据编码
+	// 压缩和加密普通数据的 value，merge 重写的数据已经是 blob 位置，不会重写 blob 文件
+	if encoded == nil && logRecord.Type == data.LogRecordNormal && !logRecord.BlobRef {
+		var err error
+		if encoded, err = db.encodeValue(logRecord.Value); err != nil {
+			return nil, err
+		}
+	}
+	if encoded != nil {
+		if encoded.blob {
+			// 超过阈值的 value 分离到 blob 文件中，数据文件中只存储 blob 位置
+			realKey, _ := parseLogRecordKey(logRecord.Key)
+			blobPos, err := db.appendBlobRecord(realKey, logRecord.Namespace, encoded, logRecord.Timestamp)
+			if err != nil {
+				return nil, err
+			}
+			logRecord.Value = data.EncodeBlobPos(blobPos)
+			logRecord.BlobRef = true
+		} else {
+			logRecord.Value, logRecord.Codec, logRecord.KeyID = encoded.value, encoded.codec, encoded.keyID
+		}
+	}
+	var blobPos *data.BlobPos
+	if logRecord.BlobRef {
+		blobPos = data.DecodeBlobPos(logRecord.Value)
+	}
+
+	// 写入数据编码，加密 key 之前记录原始的 key 用于生成 hint
+	// value 已经压缩和加密过，其他类型的数据的 value 在这里加密
+	key := logRecord.Key
+	if err := logRecord.Encrypt(db.options.Encryption, db.options.EncryptKeys); err != nil {
+		return nil, err
+	}
 	encRecord, size := data.EncodeLogRecord(logRecord)
 	// 如果写入的数据已经到达了活跃文件的阈值，则关闭活跃文件，并打开新的文件
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
+	if db.activeFile.WriteOff+size > db.options.DataFileSize && (db.lastFileId == 0 || db.activeFile.FileId < db.lastFileId) {
 		// 先持久化数据文件，保证已有的数据持久到磁盘当中
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncDataFile(db.activeFile, false); err != nil {
 			return nil, err
 		}
+		db.metrics.rotations.Inc()
 
 		// 当前活跃文件转换为旧的数据文件
 		db.olderFiles[db.activeFile.FileId] = db.activeFile
+		rotated := FileRotatedInfo{FileId: db.activeFile.FileId, Size: db.activeFile.WriteOff}
 
 		// 打开新的数据文件
 		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
 		}
+		rotated.NewFileId = db.activeFile.FileId
+		db.events.post(func(l EventListener) {
+			l.OnFileRotated(rotated)
+		})
 	}
 
 	writeOff := db.activeFile.WriteOff
 	if err := db.activeFile.Write(encRecord); err != nil {
 		return nil, err
 	}
+	db.metrics.bytesWritten.Add(uint64(size))
 
 	db.bytesWrite += uint(size)
 	// 根据用户配置决定是否持久化
-	var needSync = db.options.SyncWrites
+	var needSync = sync
 	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
 		needSync = true
 	}
 	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncWrites(); err != nil {
 			return nil, err
 		}
 		// 清空累计值
@@ -425,10 +909,63 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	// 构造内存索引信息
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint32(size)}
+	pos := &data.LogRecordPos{
+		Fid:    db.activeFile.FileId,
+		Offset: writeOff,
+		Size:   uint32(size),
+		Expire: logRecord.Expire,
+	}
+	if blobPos != nil {
+		pos.BlobFid = blobPos.Fid
+		pos.BlobSize = blobPos.Size
+	}
+	db.addActiveHint(key, logRecord.Namespace, logRecord.Type, pos)
 	return pos, nil
 }
 
+// 提前压缩和加密的 value，value 比较大时压缩和加密比较耗时，在持有互斥锁之前完成
+type encodedValue struct {
+	value []byte
+	codec data.CodecType
+	keyID uint32
+	blob  bool // value 超过阈值，需要分离到 blob 文件中
+}
+
+// 按照配置压缩和加密普通数据的 value，不需要持有互斥锁
+func (db *DB) encodeValue(value []byte) (*encodedValue, error) {
+	record := &data.LogRecord{Value: value}
+	if err := record.CompressValue(db.options.Compression); err != nil {
+		return nil, err
+	}
+	if err := record.EncryptValue(db.options.Encryption); err != nil {
+		return nil, err
+	}
+	return &encodedValue{
+		value: record.Value,
+		codec: record.Codec,
+		keyID: record.KeyID,
+		blob:  db.options.ValueThreshold > 0 && len(value) > db.options.ValueThreshold,
+	}, nil
+}
+
+// 按照配置压缩和加密 LogRecord，并进行编码
+func (db *DB) encodeLogRecord(logRecord *data.LogRecord) ([]byte, int64, error) {
+	// 按照配置的压缩算法压缩 value，merge 重写的数据也会使用当前配置的压缩算法
+	if logRecord.Type == data.LogRecordNormal && !logRecord.BlobRef {
+		if err := logRecord.CompressValue(db.options.Compression); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// 压缩之后再加密，merge 重写的数据会使用当前的密钥重新加密
+	if err := logRecord.Encrypt(db.options.Encryption, db.options.EncryptKeys); err != nil {
+		return nil, 0, err
+	}
+
+	encRecord, size := data.EncodeLogRecord(logRecord)
+	return encRecord, size, nil
+}
+
 // 设置当前活跃文件
 // 在访问此方法前必须持有互斥锁
 func (db *DB) setActiveDataFile() error {
@@ -436,12 +973,21 @@ func (db *DB) setActiveDataFile() error {
 	if db.activeFile != nil {
 		initialFileId = db.activeFile.FileId + 1
 	}
+	return db.openActiveDataFile(initialFileId)
+}
+
+// 打开指定 id 的数据文件作为当前活跃文件，原来的活跃文件的 hint 文件在此时完成
+// 在访问此方法前必须持有互斥锁
+func (db *DB) openActiveDataFile(fileId uint32) error {
 	// 打开新的数据文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fileId, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	dataFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
+	db.finishActiveHint()
 	db.activeFile = dataFile
+	db.openActiveHint()
 	return nil
 }
 
@@ -464,6 +1010,14 @@ func (db *DB) loadDataFiles() error {
 			}
 			fileIds = append(fileIds, fileId)
 		}
+		// 选择性 merge 之后的数据文件拥有独立的 hint 文件
+		if strings.HasSuffix(entry.Name(), data.HintFileNameSuffix) {
+			fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.HintFileNameSuffix))
+			if err != nil {
+				return ErrDataDirectoryCorrupted
+			}
+			db.hintFileIds[uint32(fileId)] = struct{}{}
+		}
 	}
 
 	//	对文件 id 进行排序，从小到大依次加载
@@ -480,6 +1034,7 @@ func (db *DB) loadDataFiles() error {
 		if err != nil {
 			return err
 		}
+		dataFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
 		if i == len(fileIds)-1 { // 最后一个，id是最大的，说明是当前活跃文件
 			db.activeFile = dataFile
 		} else { // 说明是旧的数据文件
@@ -509,83 +1064,133 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+	now := time.Now().UnixNano()
+	// 范围删除的记录中，value 是范围的终点
+	updateIndex := func(nsId uint32, key []byte, typ data.LogRecordType, value []byte, pos *data.LogRecordPos) error {
+		idx := db.indexOf(nsId)
+		if idx == nil {
+			return ErrDataDirectoryCorrupted
+		}
+		if typ == data.LogRecordRangeDeleted {
+			db.addNamespaceReclaimSize(nsId, pos)
+			return db.applyRangeDeleted(nsId, key, value)
+		}
+		// 合并操作数只会写入默认的 namespace
+		if typ == data.LogRecordMergeOperand && nsId == defaultNamespaceId && !pos.IsExpired(now) {
+			prev, depth := idx.Get(key), 1
+			if prev != nil {
+				if node, ok := db.operands[operandPosOf(prev)]; ok {
+					depth = node.depth + 1
+				}
+			}
+			db.putMergeOperand(key, pos, prev, depth)
+			return nil
+		}
 		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
+		// 已经过期的数据和删除的数据一样处理，直接从索引中移除
+		if typ == data.LogRecordDeleted || pos.IsExpired(now) {
+			oldPos, _ = idx.Delete(key)
+			db.addNamespaceReclaimSize(nsId, pos)
 		} else {
-			oldPos = db.index.Put(key, pos)
+			oldPos = idx.Put(key, pos)
 		}
 		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+			db.addNamespaceReclaimSize(nsId, oldPos)
 		}
+		return nil
 	}
 
 	// 暂存事务数据
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
 	var currentSeqNo = nonTransactionSeqNo
-
-	// 遍历所有的文件id，处理文件中的记录
-	for i, fid := range db.fileIds {
-		var fileId = uint32(fid)
-		// 如果比最近未参与 merge 的文件 id 更小，则说明已经从 Hint 文件中加载索引了
-		if hasMerge && fileId < nonMergeFileId {
-			continue
-		}
-		var dataFile *data.DataFile
-		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
+	// 处理数据文件中的一条记录，key 中带有事务序列号
+	applyRecord := func(logRecord *data.LogRecord, logRecordPos *data.LogRecordPos) error {
+		// 解析 key，拿到事务序列号
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		if seqNo == nonTransactionSeqNo {
+			// 非事务操作，直接更新内存索引
+			if err := updateIndex(logRecord.Namespace, realKey, logRecord.Type, logRecord.Value, logRecordPos); err != nil {
+				return err
+			}
 		} else {
-			dataFile = db.olderFiles[fileId]
-		}
-
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
+			// 事务完成，对应的 seq no 的数据可以更新到内存索引中
+			if logRecord.Type == data.LogRecordTxnFinished {
+				for _, txnRecord := range transactionRecords[seqNo] {
+					record := txnRecord.Record
+					if err := updateIndex(record.Namespace, record.Key, record.Type, record.Value, txnRecord.Pos); err != nil {
+						return err
+					}
 				}
-				return err
+				delete(transactionRecords, seqNo)
+			} else {
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+					Record: &data.LogRecord{
+						Key:       realKey,
+						Value:     logRecord.Value,
+						Type:      logRecord.Type,
+						Namespace: logRecord.Namespace,
+					},
+					Pos: logRecordPos,
+				})
 			}
+		}
 
-			// 构造内存索引并保存
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
+		// 更新事务序列号
+		if seqNo > currentSeqNo {
+			currentSeqNo = seqNo
+		}
+		return nil
+	}
 
-			// 解析 key，拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				// 非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				// 事务完成，对应的 seq no 的数据可以更新到内存索引中
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNo)
-				} else {
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
+	// 并发解码数据文件，按照文件 id 的顺序更新到索引中，保证后写入的数据覆盖之前的数据
+	loader, err := db.newDataFileLoader(hasMerge, nonMergeFileId)
+	if err != nil {
+		return err
+	}
+	defer loader.stop()
+	progress := IndexLoadProgress{TotalFiles: len(db.fileIds), TotalBytes: loader.totalBytes}
+	for i := range db.fileIds {
+		file := loader.next()
+		for _, discarded := range file.recovery.Discarded {
+			db.recovery.add(discarded)
+		}
+		if file.err != nil {
+			return file.err
+		}
+		isActive := i == len(db.fileIds)-1
+
+		switch {
+		case file.compacted:
+			// 选择性 merge 重写过的数据文件，hint 文件中的 key 不带有事务序列号
+			for _, txnRecord := range file.records {
+				record := txnRecord.Record
+				if err := updateIndex(record.Namespace, record.Key, record.Type, record.Value, txnRecord.Pos); err != nil {
+					return err
 				}
 			}
-
-			// 更新事务序列号
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
+		case !file.skipped:
+			// 活跃文件会继续写入，重新生成它的 hint 文件
+			if isActive {
+				db.openActiveHint()
+			}
+			for _, txnRecord := range file.records {
+				if err := applyRecord(txnRecord.Record, txnRecord.Pos); err != nil {
+					return err
+				}
+				if isActive {
+					db.addActiveHint(txnRecord.Record.Key, txnRecord.Record.Namespace, txnRecord.Record.Type, txnRecord.Pos)
+				}
+			}
+			// 如果是当前活跃文件，更新这个文件的 WriteOff
+			if isActive {
+				db.activeFile.WriteOff = file.offset
 			}
-
-			// 递增 offset，下一次从新的位置开始读取
-			offset += size
 		}
 
-		// 如果是当前活跃文件，更新这个文件的 WriteOff
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		progress.FilesLoaded++
+		progress.BytesLoaded += file.size
+		if db.options.OnIndexLoadProgress != nil {
+			db.options.OnIndexLoadProgress(progress)
 		}
 	}
 
@@ -594,6 +1199,48 @@ func (db *DB) loadIndexFromDataFiles() error {
 	return nil
 }
 
+// 获取数据目录的文件锁
+// 读写实例和只读实例都持有共享锁，读写实例额外持有排他的写锁，因此只读实例可以和正在运行的读写实例同时打开
+// B+ 树索引文件会被读写实例独占，使用 B+ 树索引的只读实例持有写锁的共享锁，不能和读写实例同时打开
+func lockDirectory(options Options) (*flock.Flock, *flock.Flock, error) {
+	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
+	hold, err := fileLock.TryRLock()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hold {
+		return nil, nil, ErrDatabaseIsUsing
+	}
+	if options.ReadOnly && options.IndexType != BPlusTree {
+		return fileLock, nil, nil
+	}
+
+	writerLock := flock.New(filepath.Join(options.DirPath, writerFileLockName))
+	if options.ReadOnly {
+		hold, err = writerLock.TryRLock()
+	} else {
+		hold, err = writerLock.TryLock()
+	}
+	if err == nil && !hold {
+		err = ErrDatabaseIsUsing
+	}
+	if err != nil {
+		_ = fileLock.Unlock()
+		return nil, nil, err
+	}
+	return fileLock, writerLock, nil
+}
+
+// 释放数据目录的文件锁
+func unlockDirectory(fileLock, writerLock *flock.Flock) error {
+	if writerLock != nil {
+		if err := writerLock.Unlock(); err != nil {
+			return err
+		}
+	}
+	return fileLock.Unlock()
+}
+
 func checkOptions(options Options) error {
 	if options.DirPath == "" {
 		return errors.New("database dir path is empty")
@@ -604,6 +1251,50 @@ func checkOptions(options Options) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
+	if options.RecoveryPolicy < RecoveryFail || options.RecoveryPolicy > RecoverySkipCorrupt {
+		return errors.New("unknown recovery policy")
+	}
+	if options.WatchBufferSize < 0 {
+		return errors.New("watch buffer size must not be negative")
+	}
+	if options.EventListener != nil && options.EventBufferSize <= 0 {
+		return errors.New("event buffer size must be greater than 0")
+	}
+	if _, ok := data.GetCodec(options.Compression); options.Compression != NoCompression && !ok {
+		return errors.New("unknown compression codec type")
+	}
+	if options.EncryptKeys && options.Encryption == nil {
+		return errors.New("encrypt keys requires an encryptor")
+	}
+	if options.EncryptKeys && options.IndexType == BPlusTree {
+		return ErrEncryptKeyNotSupported
+	}
+	if options.ValueThreshold < 0 {
+		return errors.New("value threshold must not be negative")
+	}
+	if options.ValueThreshold > 0 && options.BlobFileSize <= 0 {
+		return errors.New("blob file size must be greater than 0")
+	}
+	if options.BlobGCRatio < 0 || options.BlobGCRatio > 1 {
+		return errors.New("invalid blob gc ratio, must between 0 and 1")
+	}
+	if options.IndexLoadConcurrency < 0 {
+		return errors.New("index load concurrency must not be negative")
+	}
+	if options.BackgroundIOBytesPerSec < 0 {
+		return errors.New("background io bytes per sec must not be negative")
+	}
+	if options.AutoMergeInterval < 0 {
+		return errors.New("auto merge interval must not be negative")
+	}
+	if options.AutoMergeMinReclaimSize < 0 {
+		return errors.New("auto merge min reclaim size must not be negative")
+	}
+	for _, window := range options.AutoMergeWindows {
+		if window.Start < 0 || window.Start > 24*time.Hour || window.End < 0 || window.End > 24*time.Hour {
+			return errors.New("invalid auto merge window, must between 0 and 24h")
+		}
+	}
 	return nil
 }
 
@@ -625,6 +1316,10 @@ func (db *DB) loadSeqNo() error {
 	db.seqNo = seqNo
 	db.seqNoFileExists = true
 
+	// 只读模式下保留序列号文件，供读写实例下次启动时使用
+	if db.options.ReadOnly {
+		return seqNoFile.Close()
+	}
 	return os.Remove(fileName)
 }
 
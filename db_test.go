@@ -1,9 +1,13 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
+	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -322,6 +326,39 @@ func TestDB_FileLock(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestDB_FileLock_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-filelock-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(20))
+	assert.Nil(t, err)
+
+	// B+ 树索引文件被读写实例独占，只读实例不能同时打开
+	roOpts := opts
+	roOpts.ReadOnly = true
+	_, err = Open(roOpts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 有只读实例时也不能以读写模式打开
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ro.ListKeys()))
+	_, err = Open(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	assert.Nil(t, ro.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
+
 func TestDB_Stat(t *testing.T) {
 	opts := DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-stat")
@@ -374,6 +411,58 @@ func TestDB_Backup(t *testing.T) {
 	assert.NotNil(t, db2)
 }
 
+func TestDB_Backup_RateLimit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-rate-limit")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.BackgroundIOBytesPerSec = 128 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 256; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-backup-rate-limit-test")
+	defer os.RemoveAll(backupDir)
+	done := make(chan error)
+	start := time.Now()
+	go func() {
+		done <- db.Backup(backupDir)
+	}()
+
+	// 备份期间不会阻塞写入，备份中不包含开始之后写入的数据
+	time.Sleep(100 * time.Millisecond)
+	writeStart := time.Now()
+	err = db.Put([]byte("after-backup"), utils.RandomValue(10))
+	assert.Nil(t, err)
+	assert.True(t, time.Since(writeStart) < 500*time.Millisecond)
+
+	assert.Nil(t, <-done)
+	assert.True(t, time.Since(start) >= time.Second)
+
+	opts1 := DefaultOptions
+	opts1.DirPath = backupDir
+	db2, err := Open(opts1)
+	assert.Nil(t, err)
+	defer db2.Close()
+	assert.Equal(t, 256, len(db2.ListKeys()))
+	_, err = db2.Get([]byte("after-backup"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 取消限速之后备份不再等待
+	db.SetBackgroundRateLimit(0)
+	backupDir2, _ := os.MkdirTemp("", "bitcask-go-backup-rate-limit-test")
+	defer os.RemoveAll(backupDir2)
+	start = time.Now()
+	err = db.Backup(backupDir2)
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
 //func TestDB_OpenMMap(t *testing.T) {
 //	opts := DefaultOptions
 //	opts.DirPath = "/tmp/bitcask-go"
@@ -386,3 +475,536 @@ func TestDB_Backup(t *testing.T) {
 //	assert.Nil(t, err)
 //	assert.NotNil(t, db)
 //}
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.未过期之前可以正常读取
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), time.Millisecond*100)
+	assert.Nil(t, err)
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(24), 0)
+	assert.Nil(t, err)
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	// 2.过期之后 Get、ListKeys、Fold、Iterator 都看不到这个 key
+	time.Sleep(time.Millisecond * 200)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	keys := db.ListKeys()
+	assert.Equal(t, 1, len(keys))
+	assert.Equal(t, utils.GetTestKey(2), keys[0])
+
+	var foldNum int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		foldNum++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, foldNum)
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	var iterNum int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, utils.GetTestKey(2), iter.Key())
+		iterNum++
+	}
+	iter.Close()
+	assert.Equal(t, 1, iterNum)
+
+	// 3.重新 Put 之后可以正常读取
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// 4.重启之后过期的 key 依然不可见
+	err = db.PutWithTTL(utils.GetTestKey(3), utils.RandomValue(24), time.Millisecond*100)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 200)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, len(db2.ListKeys()))
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_OpenLegacyDataFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-legacy")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+
+	// 旧版本写入的数据文件和事务序列号文件，数据文件中包含 key-a 和 key-b 两条数据
+	legacyData := []byte{
+		0x28, 0xdf, 0x85, 0x67, 0x0, 0xc, 0xe, 0x0, 0x6b, 0x65, 0x79, 0x2d, 0x61, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x2d, 0x61,
+		0x71, 0x89, 0x3, 0x70, 0x0, 0xc, 0xe, 0x0, 0x6b, 0x65, 0x79, 0x2d, 0x62, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x2d, 0x62,
+	}
+	legacySeqNo := []byte{0xdc, 0x78, 0x88, 0xc0, 0x0, 0xc, 0x2, 0x73, 0x65, 0x71, 0x2e, 0x6e, 0x6f, 0x30}
+	err := os.WriteFile(data.GetDataFileName(dir, 0), legacyData, 0644)
+	assert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(dir, data.SeqNoFileName), legacySeqNo, 0644)
+	assert.Nil(t, err)
+
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-a"), val)
+
+	// 旧版本的数据之后追加写入新格式的数据
+	err = db.PutWithTTL([]byte("key-a"), []byte("value-c"), time.Hour)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-c"), val)
+	val, err = db.Get([]byte("key-b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-b"), val)
+
+	// merge 之后旧版本的数据被重写为新格式
+	beforeMerge := time.Now()
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	// 旧版本的数据没有写入时间，merge 重写之后不会被记录为 merge 的时间
+	val, err = db.GetAt([]byte("key-b"), beforeMerge)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-b"), val)
+}
+
+func TestDB_OpenReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-only")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 加载 merge 之后的数据，生成 hint 文件
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+	entries1, _ := os.ReadDir(dir)
+
+	// 1.多个只读实例可以同时打开
+	roOpts := opts
+	roOpts.ReadOnly = true
+	ro1, err := Open(roOpts)
+	assert.Nil(t, err)
+	ro2, err := Open(roOpts)
+	assert.Nil(t, err)
+
+	// 2.可以从 hint 文件和数据文件中加载索引
+	assert.Equal(t, 100000-100, len(ro1.ListKeys()))
+	_, err = ro2.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := ro2.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 3.所有的写操作都会被拒绝
+	assert.Equal(t, ErrDatabaseIsReadOnly, ro1.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+	assert.Equal(t, ErrDatabaseIsReadOnly, ro1.Delete(utils.GetTestKey(1000)))
+	assert.Equal(t, ErrDatabaseIsReadOnly, ro1.Merge())
+	wb := ro1.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Equal(t, ErrDatabaseIsReadOnly, wb.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+	assert.Equal(t, ErrDatabaseIsReadOnly, wb.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, ErrDatabaseIsReadOnly, wb.Commit())
+	_, err = ro1.Begin(false)
+	assert.Equal(t, ErrDatabaseIsReadOnly, err)
+
+	assert.Nil(t, ro1.Close())
+	assert.Nil(t, ro2.Close())
+
+	// 4.只读实例不会创建新的文件
+	entries2, _ := os.ReadDir(dir)
+	assert.Equal(t, len(entries1), len(entries2))
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 5.只读实例可以和正在运行的读写实例同时打开，读取的是打开时的数据
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	ro1, err = Open(roOpts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 100000-100, len(ro1.ListKeys()))
+	ro2, err = Open(roOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100000, len(ro2.ListKeys()))
+	// 同一时间只有一个读写实例
+	_, err = Open(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	assert.Nil(t, ro1.Close())
+	assert.Nil(t, ro2.Close())
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 6.B+ 树索引文件不存在时只读实例打开失败
+	roOpts.IndexType = BPlusTree
+	_, err = Open(roOpts)
+	assert.NotNil(t, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100000, len(db.ListKeys()))
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.Compression = GzipCompression
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value := bytes.Repeat([]byte(`{"name":"bitcask-go","type":"kv","tags":["bitcask","kv","go"]}`), 16)
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+	}
+	size1 := db.activeFile.WriteOff
+	assert.Less(t, size1, int64(1000*len(value)))
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 修改压缩算法之后，不同压缩算法的数据都可以正常读取
+	opts.Compression = NoCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 1000; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+	}
+	assert.Greater(t, db.activeFile.WriteOff-size1, int64(1000*len(value)))
+	for _, i := range []int{1, 1001} {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	// merge 时使用当前配置的压缩算法重写数据
+	db.options.Compression = FlateCompression
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(db.ListKeys()))
+	assert.Less(t, db.activeFile.WriteOff, int64(2000*len(value)))
+	for _, i := range []int{1, 1001} {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	// 未注册的压缩算法
+	opts.Compression = 100
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+// 测试使用的压缩算法，value 以 block 开头时阻塞，直到 gate 被关闭
+type blockingCodec struct {
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (c *blockingCodec) Type() data.CodecType {
+	return 101
+}
+
+func (c *blockingCodec) Compress(src []byte) ([]byte, error) {
+	if bytes.HasPrefix(src, []byte("block")) {
+		close(c.started)
+		<-c.gate
+	}
+	return src, nil
+}
+
+func (c *blockingCodec) Decompress(src []byte) ([]byte, error) {
+	return src, nil
+}
+
+func TestDB_Compression_WithoutLock(t *testing.T) {
+	codec := &blockingCodec{started: make(chan struct{}), gate: make(chan struct{})}
+	data.RegisterCodec(codec)
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression-lock")
+	opts.DirPath = dir
+	opts.Compression = codec.Type()
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	blocked := make(chan error)
+	go func() {
+		blocked <- db.Put(utils.GetTestKey(1), []byte("block-value"))
+	}()
+	<-codec.started
+
+	// 压缩 value 时不持有互斥锁，其他的写入不会被阻塞
+	done := make(chan error)
+	go func() {
+		done <- db.Put(utils.GetTestKey(2), []byte("value"))
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes are blocked by the compression of another write")
+	}
+	close(codec.gate)
+	assert.Nil(t, <-blocked)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("block-value"), val)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
+
+func TestDB_Encryption(t *testing.T) {
+	key1, key2 := bytes.Repeat([]byte("a"), 32), bytes.Repeat([]byte("b"), 32)
+	enc1, err := data.NewAESGCMEncryptor(1, map[uint32][]byte{1: key1})
+	assert.Nil(t, err)
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.Compression = GzipCompression
+	opts.Encryption = enc1
+	opts.EncryptKeys = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value := bytes.Repeat([]byte("plain-value"), 10)
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 磁盘上不存在明文的 key 和 value
+	content, err := os.ReadFile(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(content, []byte("plain-value")))
+	assert.False(t, bytes.Contains(content, utils.GetTestKey(1)))
+
+	// 没有密钥无法读取
+	opts.Encryption = nil
+	opts.EncryptKeys = false
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+
+	// 轮换密钥之后，旧的数据使用旧的密钥解密
+	enc2, err := data.NewAESGCMEncryptor(2, map[uint32][]byte{1: key1, 2: key2})
+	assert.Nil(t, err)
+	opts.Encryption = enc2
+	opts.EncryptKeys = true
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 999, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	// merge 之后使用新的密钥重新加密，包括 hint 文件
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	enc3, err := data.NewAESGCMEncryptor(2, map[uint32][]byte{2: key2})
+	assert.Nil(t, err)
+	opts.Encryption = enc3
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 999, len(db.ListKeys()))
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	err = db.Close()
+	assert.Nil(t, err)
+	content, err = os.ReadFile(filepath.Join(dir, data.HintFileName))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(content, utils.GetTestKey(1)))
+
+	// B+ 树索引不支持加密 key
+	opts.IndexType = BPlusTree
+	_, err = Open(opts)
+	assert.Equal(t, ErrEncryptKeyNotSupported, err)
+	opts.EncryptKeys = false
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_WriteReadOptions(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-write-read-options")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 1.同一个数据库中可以同时存在持久化和不持久化的写入
+	err = db.PutWithOptions(utils.GetTestKey(1), []byte("audit"), WriteOptions{Sync: true})
+	assert.Nil(t, err)
+	err = db.PutWithOptions(utils.GetTestKey(2), []byte("telemetry"), WriteOptions{Sync: false})
+	assert.Nil(t, err)
+	err = db.PutWithOptions(utils.GetTestKey(3), utils.RandomValue(24), WriteOptions{TTL: time.Millisecond * 100})
+	assert.Nil(t, err)
+	err = db.PutWithOptions(nil, utils.RandomValue(24), WriteOptions{})
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	val, err := db.GetWithOptions(utils.GetTestKey(1), ReadOptions{VerifyChecksum: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("audit"), val)
+	time.Sleep(time.Millisecond * 200)
+	_, err = db.GetWithOptions(utils.GetTestKey(3), DefaultReadOptions)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.DeleteWithOptions(utils.GetTestKey(2), WriteOptions{Sync: false})
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 2.损坏的数据只有在校验 CRC 时才能发现
+	pos := db.index.Get(utils.GetTestKey(1))
+	file, err := os.OpenFile(data.GetDataFileName(dir, pos.Fid), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("A"), pos.Offset+int64(pos.Size)-1)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	val, err = db.GetWithOptions(utils.GetTestKey(1), ReadOptions{VerifyChecksum: false})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("audiA"), val)
+
+	// 3.重启之后数据依然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.RecoveryPolicy = RecoverySkipCorrupt
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_MultiGet(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-multi-get")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values, errs := db.MultiGet([][]byte{utils.GetTestKey(1)})
+	assert.Nil(t, values[0])
+	assert.Equal(t, ErrKeyNotFound, errs[0])
+
+	// 数据分布在多个数据文件中
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.PutWithTTL(utils.GetTestKey(100), utils.RandomValue(24), time.Millisecond*50)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 100)
+	assert.True(t, len(db.olderFiles) > 0)
+
+	var keys [][]byte
+	for i := 999; i >= 0; i -= 7 {
+		keys = append(keys, utils.GetTestKey(i))
+	}
+	keys = append(keys, nil, utils.GetTestKey(100), utils.GetTestKey(5000), utils.GetTestKey(999))
+
+	values, errs = db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(values))
+	assert.Equal(t, len(keys), len(errs))
+	for i, key := range keys {
+		if len(key) == 0 {
+			assert.Equal(t, ErrKeyIsEmpty, errs[i])
+			continue
+		}
+		val, err := db.Get(key)
+		assert.Equal(t, err, errs[i])
+		assert.Equal(t, val, values[i])
+	}
+	assert.Equal(t, ErrKeyNotFound, errs[len(keys)-3])
+	assert.NotNil(t, values[len(keys)-1])
+}
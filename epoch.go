@@ -0,0 +1,187 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"math"
+	"os"
+	"sync"
+)
+
+// 读取者没有持有版本，读取当前的数据文件
+const latestEpoch uint64 = math.MaxUint64
+
+// 数据文件的版本管理
+// merge 在线替换数据文件之后版本递增，迭代器和事务等读取者会持有创建时的版本，
+// 被替换下来的数据文件在更早版本的读取者全部结束之后才会关闭和删除
+type fileEpochs struct {
+	mu      *sync.Mutex
+	dirPath string
+	current uint64                    // 当前版本
+	readers map[uint64]int            // 每个版本中还没有结束的读取者数量
+	retired map[uint64][]*retiredFile // 每个版本结束时被替换下来的数据文件
+	files   map[uint32][]*retiredFile // 被替换下来但还没有关闭的数据文件，选择性 merge 原地替换的文件 id 可能有多个版本
+
+	operands map[uint64]map[operandPos]*operandNode // 每个版本结束时被替换下来的数据文件中的合并操作数记录
+}
+
+// 被替换下来的数据文件
+type retiredFile struct {
+	file     *data.DataFile
+	epoch    uint64 // 被替换下来时的版本，持有这个版本以及更早版本的读取者读取的是这个文件
+	replaced bool   // 是否被 id 相同的数据文件原地替换，原地替换的文件只需要关闭，不能按照文件名删除
+}
+
+func newFileEpochs(dirPath string) *fileEpochs {
+	return &fileEpochs{
+		mu:      new(sync.Mutex),
+		dirPath: dirPath,
+		readers: make(map[uint64]int),
+		retired: make(map[uint64][]*retiredFile),
+		files:   make(map[uint32][]*retiredFile),
+
+		operands: make(map[uint64]map[operandPos]*operandNode),
+	}
+}
+
+// 注册读取者，返回当前的版本
+// 在访问此方法前必须持有 db 的读锁，保证注册期间不会替换数据文件
+func (fe *fileEpochs) acquire() uint64 {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.readers[fe.current]++
+	return fe.current
+}
+
+// 读取者结束，关闭不再被使用的数据文件
+func (fe *fileEpochs) release(epoch uint64) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if fe.readers[epoch]--; fe.readers[epoch] <= 0 {
+		delete(fe.readers, epoch)
+	}
+	fe.cleanup()
+}
+
+// 当前的版本
+// 在访问此方法前必须持有 db 的读锁或者互斥锁，保证期间不会替换数据文件
+func (fe *fileEpochs) latest() uint64 {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	return fe.current
+}
+
+// 替换下来的数据文件进入当前版本，并开始新的版本
+// replaced 表示数据文件被 id 相同的新文件原地替换
+// 在访问此方法前必须持有 db 的互斥锁
+func (fe *fileEpochs) retire(files []*data.DataFile, replaced bool) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	for _, file := range files {
+		rf := &retiredFile{file: file, epoch: fe.current, replaced: replaced}
+		fe.retired[fe.current] = append(fe.retired[fe.current], rf)
+		fe.files[file.FileId] = append(fe.files[file.FileId], rf)
+	}
+	fe.current++
+	fe.cleanup()
+}
+
+// 被替换下来的数据文件中的合并操作数记录进入当前版本，和数据文件一起保留到更早版本的读取者结束
+// 在访问此方法前必须持有 db 的互斥锁
+func (fe *fileEpochs) retireOperand(opPos operandPos, node *operandNode) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	nodes, ok := fe.operands[fe.current]
+	if !ok {
+		nodes = make(map[operandPos]*operandNode)
+		fe.operands[fe.current] = nodes
+	}
+	nodes[opPos] = node
+}
+
+// 获取持有指定版本的读取者读取的被替换下来的合并操作数记录，没有被替换时返回 nil
+func (fe *fileEpochs) getOperand(opPos operandPos, epoch uint64) *operandNode {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	var found *operandNode
+	var foundEpoch uint64
+	for retiredEpoch, nodes := range fe.operands {
+		if retiredEpoch < epoch || (found != nil && retiredEpoch >= foundEpoch) {
+			continue
+		}
+		if node, ok := nodes[opPos]; ok {
+			found, foundEpoch = node, retiredEpoch
+		}
+	}
+	return found
+}
+
+// 获取持有指定版本的读取者读取的被替换下来的数据文件，数据文件在这个版本之后没有被替换时返回 nil
+func (fe *fileEpochs) get(fileId uint32, epoch uint64) *data.DataFile {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	var found *retiredFile
+	for _, rf := range fe.files[fileId] {
+		if rf.epoch >= epoch && (found == nil || rf.epoch < found.epoch) {
+			found = rf
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	return found.file
+}
+
+// 关闭并删除没有读取者的版本中被替换下来的数据文件
+// 删除失败的文件会在下一次启动时删除
+func (fe *fileEpochs) cleanup() {
+	var minEpoch = fe.current
+	for epoch := range fe.readers {
+		if epoch < minEpoch {
+			minEpoch = epoch
+		}
+	}
+	for epoch, files := range fe.retired {
+		if epoch >= minEpoch {
+			continue
+		}
+		fe.removeFiles(files)
+		delete(fe.retired, epoch)
+	}
+	for epoch := range fe.operands {
+		if epoch < minEpoch {
+			delete(fe.operands, epoch)
+		}
+	}
+}
+
+// 关闭并删除所有被替换下来的数据文件，数据库关闭时调用
+func (fe *fileEpochs) close() {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	for epoch, files := range fe.retired {
+		fe.removeFiles(files)
+		delete(fe.retired, epoch)
+	}
+	fe.operands = make(map[uint64]map[operandPos]*operandNode)
+}
+
+func (fe *fileEpochs) removeFiles(files []*retiredFile) {
+	for _, rf := range files {
+		_ = rf.file.Close()
+		if !rf.replaced {
+			_ = os.Remove(data.GetDataFileName(fe.dirPath, rf.file.FileId))
+		}
+		versions := fe.files[rf.file.FileId]
+		for i, version := range versions {
+			if version == rf {
+				versions = append(versions[:i], versions[i+1:]...)
+				break
+			}
+		}
+		if len(versions) == 0 {
+			delete(fe.files, rf.file.FileId)
+		} else {
+			fe.files[rf.file.FileId] = versions
+		}
+	}
+}
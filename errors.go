@@ -13,4 +13,25 @@ var (
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
 	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrSeqNoFileNotExists     = errors.New("cannot use transaction, seq no file not exists")
+	ErrTxnConflict            = errors.New("transaction conflict, the keys read were modified by another commit")
+	ErrTxnReadOnly            = errors.New("cannot write in a read-only transaction")
+	ErrTxnClosed              = errors.New("transaction has been committed or rolled back")
+	ErrDatabaseIsReadOnly     = errors.New("the database is opened in read-only mode")
+	ErrBlobGCRatioUnreached   = errors.New("the blob gc ratio do not reach the option")
+	ErrNamespaceIsEmpty       = errors.New("the namespace name is empty")
+	ErrNamespaceNotSupported  = errors.New("namespace is not supported by b+ tree index")
+	ErrMergeFilesNotSupported = errors.New("selective merge is not supported by b+ tree index")
+	ErrInvalidMergeOptions    = errors.New("invalid merge options, file garbage ratio must between 0 and 1 and top n must not be negative")
+	ErrDuplicateFileId        = errors.New("duplicate data file id in the database directory")
+	ErrOrphanedFile           = errors.New("orphaned file that will not be used by the database")
+	ErrIndexEntryInvalid      = errors.New("index entry does not point to a valid log record")
+	ErrIndexKeyMismatch       = errors.New("index entry does not match the key of the log record")
+	ErrInvalidKeyRange        = errors.New("invalid key range, start must be less than end")
+	ErrMergeOperatorNotFound  = errors.New("merge operator is not registered")
+	ErrInvalidMergeOperand    = errors.New("invalid merge operand or value for the merge operator")
+	ErrWritePanicked          = errors.New("the write panicked while holding the database lock")
+	ErrDecodePanicked         = errors.New("decoding the data file panicked, the data file maybe corrupted")
+	ErrMergeFileIdOverflow    = errors.New("merge output exceeds the reserved data file ids")
+	ErrEncryptKeyNotSupported = errors.New("encrypt keys is not supported by b+ tree index, the index file must keep plaintext keys in order for lookups and iteration")
 )
@@ -0,0 +1,168 @@
+package bitcask_go
+
+import (
+	"sync"
+	"time"
+)
+
+// EventListener 存储引擎内部事件的监听者，通过 Options.EventListener 配置
+// 回调在独立的协程中按照事件发生的顺序依次执行，不会持有 DB 的锁，执行较慢的回调不会阻塞写入
+// 等待处理的事件超过 Options.EventBufferSize 时新的事件会被丢弃，丢弃的数量通过 Stat.DroppedEvents 查看
+// 不需要监听所有事件时可以嵌入 BaseEventListener
+type EventListener interface {
+	// OnFileRotated 活跃数据文件写满，转换为旧的数据文件并打开新的活跃文件
+	OnFileRotated(info FileRotatedInfo)
+
+	// OnMergeBegin merge 选出需要重写的数据文件之后开始执行
+	OnMergeBegin(info MergeBeginInfo)
+
+	// OnMergeEnd merge 执行结束，执行失败时 info.Err 不为空
+	OnMergeEnd(info MergeEndInfo)
+
+	// OnRecovery 启动时按照恢复策略丢弃了损坏的数据
+	OnRecovery(report RecoveryReport)
+
+	// OnSync 持久化活跃文件，持久化失败时 info.Err 不为空
+	OnSync(info SyncInfo)
+
+	// OnBackgroundError 后台任务执行失败，例如后台自动 merge
+	OnBackgroundError(err error)
+}
+
+// FileRotatedInfo 活跃数据文件转换的信息
+type FileRotatedInfo struct {
+	FileId    uint32 // 写满的数据文件 id
+	Size      int64  // 写满的数据文件大小
+	NewFileId uint32 // 新的活跃文件 id
+}
+
+// MergeBeginInfo merge 开始的信息
+type MergeBeginInfo struct {
+	Selective bool     // 是否是 MergeFiles 执行的选择性 merge
+	FileIds   []uint32 // 参与 merge 的数据文件 id
+}
+
+// MergeEndInfo merge 结束的信息
+type MergeEndInfo struct {
+	MergeBeginInfo
+	Duration       time.Duration // merge 的耗时
+	ReclaimedBytes int64         // 回收的空间，字节为单位，merge 的结果在结束时已经替换到数据目录中
+	Err            error
+}
+
+// SyncInfo 持久化的信息
+type SyncInfo struct {
+	FileId   uint32
+	Blob     bool // 是否是 blob 文件
+	Duration time.Duration
+	Err      error
+}
+
+// BaseEventListener 不做任何处理的监听者，可以嵌入到自定义的监听者中
+type BaseEventListener struct{}
+
+func (BaseEventListener) OnFileRotated(FileRotatedInfo) {}
+
+func (BaseEventListener) OnMergeBegin(MergeBeginInfo) {}
+
+func (BaseEventListener) OnMergeEnd(MergeEndInfo) {}
+
+func (BaseEventListener) OnRecovery(RecoveryReport) {}
+
+func (BaseEventListener) OnSync(SyncInfo) {}
+
+func (BaseEventListener) OnBackgroundError(error) {}
+
+// 将事件按照顺序投递给监听者，没有配置监听者时为 nil
+type eventDispatcher struct {
+	listener EventListener
+	mu       *sync.Mutex
+	cond     *sync.Cond
+	queue    []func(EventListener)
+	size     int    // 最多暂存的事件数量
+	dropped  uint64 // 队列已满时丢弃的事件数量
+	closed   bool
+	doneCh   chan struct{}
+}
+
+func newEventDispatcher(listener EventListener, size int) *eventDispatcher {
+	if listener == nil {
+		return nil
+	}
+	mu := new(sync.Mutex)
+	d := &eventDispatcher{
+		listener: listener,
+		mu:       mu,
+		cond:     sync.NewCond(mu),
+		size:     size,
+		doneCh:   make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// 投递事件，不会阻塞调用者，可以在持有 DB 的锁时调用
+// 监听者处理过慢、暂存的事件达到上限时丢弃新的事件
+func (d *eventDispatcher) post(fn func(EventListener)) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	if len(d.queue) >= d.size {
+		d.dropped++
+		return
+	}
+	d.queue = append(d.queue, fn)
+	d.cond.Signal()
+}
+
+func (d *eventDispatcher) run() {
+	defer close(d.doneCh)
+	for {
+		d.mu.Lock()
+		for len(d.queue) == 0 && !d.closed {
+			d.cond.Wait()
+		}
+		queue := d.queue
+		d.queue = nil
+		closed := d.closed
+		d.mu.Unlock()
+
+		for _, fn := range queue {
+			fn(d.listener)
+		}
+		if closed && len(queue) == 0 {
+			return
+		}
+	}
+}
+
+// 返回丢弃的事件数量
+func (d *eventDispatcher) droppedEvents() uint64 {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dropped
+}
+
+// 停止投递，等待已经产生的事件全部执行完成
+func (d *eventDispatcher) close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	d.cond.Signal()
+	d.mu.Unlock()
+	<-d.doneCh
+}
@@ -0,0 +1,164 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录所有事件的监听者
+type recordingListener struct {
+	BaseEventListener
+	mu         sync.Mutex
+	rotated    []FileRotatedInfo
+	mergeBegin []MergeBeginInfo
+	mergeEnd   []MergeEndInfo
+	recovery   []RecoveryReport
+	syncs      int
+	gate       chan struct{} // 不为空时阻塞文件转换的回调，直到 gate 被关闭
+}
+
+func (l *recordingListener) OnFileRotated(info FileRotatedInfo) {
+	if l.gate != nil {
+		<-l.gate
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rotated = append(l.rotated, info)
+}
+
+func (l *recordingListener) OnMergeBegin(info MergeBeginInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mergeBegin = append(l.mergeBegin, info)
+}
+
+func (l *recordingListener) OnMergeEnd(info MergeEndInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mergeEnd = append(l.mergeEnd, info)
+}
+
+func (l *recordingListener) OnRecovery(report RecoveryReport) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recovery = append(l.recovery, report)
+}
+
+func (l *recordingListener) OnSync(info SyncInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.syncs++
+}
+
+func TestDB_EventListener(t *testing.T) {
+	listener := &recordingListener{}
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-event-listener")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.EventListener = listener
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 200; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 150; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.MergeFiles(DefaultMergeOptions)
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 关闭时等待所有的事件投递完成
+	err = db.Close()
+	assert.Nil(t, err)
+
+	assert.True(t, len(listener.rotated) > 0)
+	for i, info := range listener.rotated {
+		assert.Equal(t, uint32(i), info.FileId)
+		assert.Equal(t, uint32(i+1), info.NewFileId)
+		assert.True(t, info.Size > 0 && info.Size <= opts.DataFileSize)
+	}
+	assert.True(t, listener.syncs >= len(listener.rotated))
+
+	assert.Equal(t, 2, len(listener.mergeBegin))
+	assert.Equal(t, 2, len(listener.mergeEnd))
+	assert.True(t, listener.mergeBegin[0].Selective)
+	assert.False(t, listener.mergeBegin[1].Selective)
+	for _, info := range listener.mergeEnd {
+		assert.Nil(t, info.Err)
+		assert.True(t, len(info.FileIds) > 0)
+		assert.True(t, info.ReclaimedBytes > 0)
+		assert.True(t, info.Duration > 0)
+	}
+	assert.Equal(t, 0, len(listener.recovery))
+
+	// 模拟写入过程中崩溃，活跃文件末尾只写入了半条数据
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte("torn"), Value: utils.RandomValue(128)})
+	file, err := os.OpenFile(data.GetDataFileName(dir, db.activeFile.FileId), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(encRecord[:len(encRecord)/2])
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	opts.RecoveryPolicy = RecoveryTruncateTail
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(listener.recovery))
+	assert.Equal(t, int64(len(encRecord)/2), listener.recovery[0].DiscardedBytes)
+}
+
+func TestDB_EventListener_SlowListener(t *testing.T) {
+	listener := &recordingListener{gate: make(chan struct{})}
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-event-listener-slow")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.SyncWrites = true
+	opts.EventListener = listener
+	opts.EventBufferSize = 16
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 监听者阻塞时写入不受影响
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+			assert.Nil(t, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes are blocked by the event listener")
+	}
+
+	listener.mu.Lock()
+	assert.Equal(t, 0, len(listener.rotated))
+	listener.mu.Unlock()
+
+	// 每次写入都会产生持久化事件，等待处理的事件不会超过上限，多出的事件被丢弃
+	db.events.mu.Lock()
+	assert.True(t, len(db.events.queue) <= opts.EventBufferSize)
+	db.events.mu.Unlock()
+	assert.True(t, db.Stat().DroppedEvents > 0)
+	close(listener.gate)
+}
@@ -28,6 +28,9 @@ type IOManager interface {
 
 	// Size 获取到文件大小
 	Size() (int64, error)
+
+	// Truncate 将文件截断到指定大小
+	Truncate(int64) error
 }
 
 // NewIOManager 初始化 IOManager，目前只支持标准 FileIO
@@ -7,6 +7,7 @@ import (
 
 // MMap IO，内存文件映射
 type MMap struct {
+	fileName string
 	readerAt *mmap.ReaderAt
 }
 
@@ -20,7 +21,7 @@ func NewMMapIOManager(fileName string) (*MMap, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &MMap{readerAt: readerAt}, nil
+	return &MMap{fileName: fileName, readerAt: readerAt}, nil
 }
 
 func (mmap *MMap) Read(b []byte, offset int64) (int, error) {
@@ -35,6 +36,22 @@ func (mmap *MMap) Sync() error {
 	panic("not implemented")
 }
 
+// Truncate 截断文件之后重新映射
+func (mmap *MMap) Truncate(size int64) error {
+	if err := os.Truncate(mmap.fileName, size); err != nil {
+		return err
+	}
+	if err := mmap.readerAt.Close(); err != nil {
+		return err
+	}
+	readerAt, err := openMMap(mmap.fileName)
+	if err != nil {
+		return err
+	}
+	mmap.readerAt = readerAt
+	return nil
+}
+
 func (mmap *MMap) Close() error {
 	return mmap.readerAt.Close()
 }
@@ -42,3 +59,7 @@ func (mmap *MMap) Close() error {
 func (mmap *MMap) Size() (int64, error) {
 	return int64(mmap.readerAt.Len()), nil
 }
+
+func openMMap(fileName string) (*mmap.ReaderAt, error) {
+	return mmap.Open(fileName)
+}
@@ -0,0 +1,242 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	fileHintKey    = "file-hint"     // 写入数据时生成的 hint 文件的起始标识
+	fileHintFinKey = "file-hint.fin" // hint 文件完成的标识，value 是 hint 覆盖的数据文件大小
+
+	// hint 记录暂存的数据量，达到之后写到 hint 文件中
+	fileHintBufferSize = 64 * 1024
+)
+
+// 活跃文件的 hint 文件，写入数据时同步追加索引信息，活跃文件写满或者关闭数据库时写入完成标识并持久化
+// 和选择性 merge 生成的 hint 文件不同，记录中保存的是带有事务序列号的 key，加载时和数据文件一样处理事务
+type fileHintWriter struct {
+	file *data.DataFile
+	buf  []byte // 还没有写到 hint 文件中的记录
+}
+
+func newFileHintWriter(dirPath string, fileId uint32, options Options) (*fileHintWriter, error) {
+	// 删除之前的 hint 文件，重新生成
+	if err := os.Remove(data.GetHintFileName(dirPath, fileId)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	hintFile, err := data.OpenFileHintFile(dirPath, fileId)
+	if err != nil {
+		return nil, err
+	}
+	hintFile.SetEncryptor(options.Encryption, options.EncryptKeys)
+	header, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte(fileHintKey), Type: data.LogRecordTxnFinished})
+	return &fileHintWriter{file: hintFile, buf: header}, nil
+}
+
+// 追加一条数据记录的索引信息，key 是写到数据文件中的 key
+func (w *fileHintWriter) add(key []byte, nsId uint32, typ data.LogRecordType, pos *data.LogRecordPos) error {
+	encRecord, err := w.file.EncodeHintRecord(key, nsId, typ, pos)
+	if err != nil {
+		return err
+	}
+	w.buf = append(w.buf, encRecord...)
+	if len(w.buf) >= fileHintBufferSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *fileHintWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if err := w.file.Write(w.buf); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// 写入完成标识，记录 hint 覆盖的数据文件大小，持久化并关闭 hint 文件
+func (w *fileHintWriter) finish(size int64) error {
+	fin, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(fileHintFinKey),
+		Value: []byte(strconv.FormatInt(size, 10)),
+		Type:  data.LogRecordTxnFinished,
+	})
+	w.buf = append(w.buf, fin...)
+	if err := w.flush(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// 为当前活跃文件生成 hint 文件，生成失败时不影响写入，加载时扫描数据文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) openActiveHint() {
+	if !db.fileHints || db.activeFile == nil {
+		return
+	}
+	writer, err := newFileHintWriter(db.options.DirPath, db.activeFile.FileId, db.options)
+	if err != nil {
+		db.events.post(func(l EventListener) {
+			l.OnBackgroundError(err)
+		})
+		return
+	}
+	db.activeHint = writer
+}
+
+// 记录写入活跃文件的数据的索引信息
+// 在访问此方法前必须持有互斥锁
+func (db *DB) addActiveHint(key []byte, nsId uint32, typ data.LogRecordType, pos *data.LogRecordPos) {
+	if db.activeHint == nil {
+		return
+	}
+	if err := db.activeHint.add(key, nsId, typ, pos); err != nil {
+		db.abortActiveHint(err)
+	}
+}
+
+// 活跃文件写满或者关闭数据库，完成活跃文件的 hint 文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) finishActiveHint() {
+	if db.activeHint == nil {
+		return
+	}
+	if err := db.activeHint.finish(db.activeFile.WriteOff); err != nil {
+		db.abortActiveHint(err)
+		return
+	}
+	db.activeHint = nil
+}
+
+// 放弃活跃文件的 hint 文件，加载时扫描数据文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) abortActiveHint(err error) {
+	_ = db.activeHint.file.Close()
+	_ = os.Remove(data.GetHintFileName(db.options.DirPath, db.activeHint.file.FileId))
+	db.activeHint = nil
+	db.events.post(func(l EventListener) {
+		l.OnBackgroundError(err)
+	})
+}
+
+// 为启动时扫描过的旧数据文件生成 hint 文件，生成失败时下一次启动依然扫描数据文件
+func (db *DB) writeFileHint(fileId uint32, records []*data.TransactionRecord, size int64) {
+	err := func() error {
+		writer, err := newFileHintWriter(db.options.DirPath, fileId, db.options)
+		if err != nil {
+			return err
+		}
+		for _, txnRecord := range records {
+			record := txnRecord.Record
+			if err := writer.add(record.Key, record.Namespace, record.Type, txnRecord.Pos); err != nil {
+				_ = writer.file.Close()
+				return err
+			}
+		}
+		return writer.finish(size)
+	}()
+	if err != nil {
+		_ = os.Remove(data.GetHintFileName(db.options.DirPath, fileId))
+		db.events.post(func(l EventListener) {
+			l.OnBackgroundError(err)
+		})
+	}
+}
+
+// 单个数据文件的 hint 文件中的索引信息
+type fileHint struct {
+	records []*data.TransactionRecord
+	size    int64 // hint 覆盖的数据文件大小，之后的数据需要扫描数据文件
+	sealed  bool  // 是否是写入数据时生成的 hint 文件，key 中带有事务序列号
+}
+
+// 读取数据文件对应的 hint 文件
+// hint 文件损坏、没有完成或者和数据文件不一致时返回 nil，需要扫描数据文件加载索引
+func (db *DB) readFileHint(dataFile *data.DataFile) *fileHint {
+	hintFile, err := data.OpenFileHintFile(db.options.DirPath, dataFile.FileId)
+	if err != nil {
+		return nil
+	}
+	defer hintFile.Close()
+	hintFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
+	dataSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil
+	}
+
+	hint := &fileHint{size: -1}
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil
+		}
+		offset += size
+
+		if logRecord.Type == data.LogRecordTxnFinished {
+			if offset == size && string(logRecord.Key) == fileHintKey {
+				hint.sealed = true
+				continue
+			}
+			if hint.sealed && string(logRecord.Key) == fileHintFinKey {
+				hint.size, err = strconv.ParseInt(string(logRecord.Value), 10, 64)
+				if err != nil {
+					return nil
+				}
+				break
+			}
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		// hint 文件中只有位置信息，范围删除的终点需要从数据文件中读取
+		var value []byte
+		if logRecord.Type == data.LogRecordRangeDeleted {
+			rangeRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+			if err != nil {
+				return nil
+			}
+			value = rangeRecord.Value
+		}
+		hint.records = append(hint.records, &data.TransactionRecord{
+			Record: &data.LogRecord{
+				Key:       logRecord.Key,
+				Value:     value,
+				Type:      logRecord.Type,
+				Namespace: logRecord.Namespace,
+			},
+			Pos: pos,
+		})
+	}
+
+	// 选择性 merge 生成的 hint 文件覆盖整个数据文件
+	if !hint.sealed {
+		if len(hint.records) == 0 {
+			return nil
+		}
+		hint.size = dataSize
+	}
+	// 没有完成标识，或者数据文件中的数据少于 hint 覆盖的大小
+	if hint.size < 0 || hint.size > dataSize {
+		return nil
+	}
+	return hint
+}
+
+// 是否是写入数据时生成的 hint 文件的起始或者完成标识
+func isFileHintMarker(logRecord *data.LogRecord) bool {
+	return logRecord.Type == data.LogRecordTxnFinished &&
+		(string(logRecord.Key) == fileHintKey || string(logRecord.Key) == fileHintFinKey)
+}
@@ -0,0 +1,152 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_FileHint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 300; i++ {
+		key := utils.GetTestKey(i)
+		values[string(key)] = utils.RandomValue(256)
+		err := db.Put(key, values[string(key)])
+		assert.Nil(t, err)
+	}
+	// 跨越多个数据文件的事务
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 300; i < 500; i++ {
+		key := utils.GetTestKey(i)
+		values[string(key)] = utils.RandomValue(256)
+		_ = wb.Put(key, values[string(key)])
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, string(utils.GetTestKey(i)))
+	}
+	err = db.PutWithTTL([]byte("expired"), []byte("value"), time.Millisecond)
+	assert.Nil(t, err)
+	err = db.DeleteRange(utils.GetTestKey(100), utils.GetTestKey(110))
+	assert.Nil(t, err)
+	for i := 100; i < 110; i++ {
+		delete(values, string(utils.GetTestKey(i)))
+	}
+	seqNo := db.seqNo
+	lastFileId := db.activeFile.FileId
+	assert.True(t, lastFileId > 2)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 每个数据文件都有完整的 hint 文件
+	for fileId := uint32(0); fileId <= lastFileId; fileId++ {
+		_, err := os.Stat(data.GetHintFileName(dir, fileId))
+		assert.Nil(t, err)
+	}
+
+	check := func(db *DB) {
+		assert.Equal(t, len(values), len(db.ListKeys()))
+		for key, value := range values {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		_, err := db.Get([]byte("expired"))
+		assert.Equal(t, ErrKeyNotFound, err)
+		assert.Equal(t, seqNo, db.seqNo)
+	}
+
+	// 破坏旧数据文件中一条已经被删除的数据，从 hint 文件加载索引时不会扫描数据文件
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted"), 100)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	opts.RecoveryPolicy = RecoveryFail
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// hint 文件不存在时扫描数据文件
+	assert.Nil(t, os.Remove(data.GetHintFileName(dir, 0)))
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+}
+
+func TestDB_FileHint_Fallback(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-hint-fallback")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 300; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(256))
+		assert.Nil(t, err)
+	}
+	activeFileId := db.activeFile.FileId
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 截断旧数据文件的 hint 文件，模拟写入 hint 文件的过程中崩溃
+	hintFileName := data.GetHintFileName(dir, 1)
+	info, err := os.Stat(hintFileName)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(hintFileName, info.Size()/2))
+
+	// 关闭之后活跃文件又写入了数据，hint 文件只覆盖了之前的数据
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("after-close"), nonTransactionSeqNo),
+		Value: []byte("value"),
+	})
+	file, err := os.OpenFile(data.GetDataFileName(dir, activeFileId), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(encRecord)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 301, len(db.ListKeys()))
+	val, err := db.Get([]byte("after-close"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// 重新生成的活跃文件 hint 文件包含启动之前的数据
+	err = db.Put([]byte("after-open"), []byte("value"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	// 只读模式下不会重新生成活跃文件的 hint 文件
+	opts.ReadOnly = true
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	hint := db.readFileHint(db.activeFile)
+	assert.NotNil(t, hint)
+	assert.True(t, hint.sealed)
+	assert.Equal(t, db.activeFile.WriteOff, hint.size)
+	assert.Equal(t, 302, len(db.ListKeys()))
+}
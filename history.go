@@ -0,0 +1,140 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"io"
+	"sort"
+	"time"
+)
+
+// GetAt 读取 key 在指定时间点的值，即写入时间不晚于 t 的最新版本
+// 只能查找到数据文件中还保留着的历史版本，merge 会清理掉 Options.MergeRetention 之外的历史版本
+// 分离到 blob 文件中的历史版本在 blob 文件被回收之后无法读取
+func (db *DB) GetAt(key []byte, t time.Time) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	// 取出当前所有的数据文件，以及活跃文件已经写入的位置
+	db.mu.RLock()
+	if db.activeFile == nil {
+		db.mu.RUnlock()
+		return nil, ErrKeyNotFound
+	}
+	dataFiles := make([]*data.DataFile, 0, len(db.olderFiles)+1)
+	for _, file := range db.olderFiles {
+		dataFiles = append(dataFiles, file)
+	}
+	dataFiles = append(dataFiles, db.activeFile)
+	activeFileId, activeWriteOff := db.activeFile.FileId, db.activeFile.WriteOff
+	// 读取期间 merge 替换下来的数据文件不会被删除
+	epoch := db.epochs.acquire()
+	db.mu.RUnlock()
+	defer db.epochs.release(epoch)
+
+	sort.Slice(dataFiles, func(i, j int) bool {
+		return dataFiles[i].FileId < dataFiles[j].FileId
+	})
+
+	ts := t.UnixNano()
+	var found *data.LogRecord
+	// found 之后写入的合并操作数
+	var operands []*data.LogRecord
+	apply := func(record *data.LogRecord) {
+		if record.Timestamp > ts {
+			return
+		}
+		if record.Type == data.LogRecordMergeOperand {
+			operands = append(operands, record)
+		} else {
+			found, operands = record, nil
+		}
+	}
+
+	// 按照写入的顺序遍历所有的记录，事务数据在事务完成之后才生效
+	transactionRecords := make(map[uint64][]*data.LogRecord)
+	for _, dataFile := range dataFiles {
+		var offset int64 = 0
+		for {
+			if dataFile.FileId == activeFileId && offset >= activeWriteOff {
+				break
+			}
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				// 跳过启动时已经按照恢复策略丢弃的损坏数据
+				next, err := db.skipCorruptRecord(dataFile, offset, err)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				offset = next
+				continue
+			}
+			offset += size
+
+			realKey, seqNo := parseLogRecordKey(logRecord.Key)
+			if logRecord.Type == data.LogRecordTxnFinished {
+				for _, record := range transactionRecords[seqNo] {
+					apply(record)
+				}
+				delete(transactionRecords, seqNo)
+				continue
+			}
+			if logRecord.Namespace != defaultNamespaceId {
+				continue
+			}
+			// 范围删除的记录覆盖了 key 的话视为一次删除
+			if logRecord.Type == data.LogRecordRangeDeleted {
+				if keyInRange(key, realKey, logRecord.Value) {
+					apply(logRecord)
+				}
+				continue
+			}
+			if !bytes.Equal(realKey, key) {
+				continue
+			}
+			if seqNo == nonTransactionSeqNo {
+				apply(logRecord)
+			} else {
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], logRecord)
+			}
+		}
+	}
+
+	// 合并操作数的过期时间和原来的值一致
+	latest := found
+	if len(operands) > 0 {
+		latest = operands[len(operands)-1]
+	}
+	if latest == nil || (latest.Expire > 0 && latest.Expire <= ts) {
+		return nil, ErrKeyNotFound
+	}
+	var value []byte
+	exists := found != nil && found.Type == data.LogRecordNormal
+	if exists {
+		value = found.Value
+		// 历史版本所在的 blob 文件有可能已经被回收
+		if found.BlobRef {
+			var err error
+			db.mu.RLock()
+			value, err = db.readBlobValue(data.DecodeBlobPos(found.Value), DefaultReadOptions)
+			db.mu.RUnlock()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(operands) > 0 {
+		return applyMergeOperands(value, exists, operands)
+	}
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
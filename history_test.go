@@ -0,0 +1,129 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_GetAt(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-get-at")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 数据库为空
+	_, err = db.GetAt(utils.GetTestKey(1), time.Now())
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	t0 := time.Now()
+	time.Sleep(time.Millisecond * 5)
+	val1 := utils.RandomValue(10)
+	err = db.Put(utils.GetTestKey(1), val1)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	t1 := time.Now()
+	time.Sleep(time.Millisecond * 5)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	val2 := utils.RandomValue(10)
+	_ = wb.Put(utils.GetTestKey(1), val2)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	t2 := time.Now()
+	time.Sleep(time.Millisecond * 5)
+
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	t3 := time.Now()
+
+	_, err = db.GetAt(utils.GetTestKey(1), t0)
+	assert.Equal(t, ErrKeyNotFound, err)
+	v, err := db.GetAt(utils.GetTestKey(1), t1)
+	assert.Nil(t, err)
+	assert.Equal(t, val1, v)
+	v, err = db.GetAt(utils.GetTestKey(1), t2)
+	assert.Nil(t, err)
+	assert.Equal(t, val2, v)
+	_, err = db.GetAt(utils.GetTestKey(1), t3)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后依然可以读取历史版本
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	v, err = db.GetAt(utils.GetTestKey(1), t1)
+	assert.Nil(t, err)
+	assert.Equal(t, val1, v)
+}
+
+func TestDB_Merge_Retention(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-retention")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.MergeRetention = time.Hour
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	val1 := utils.RandomValue(10)
+	err = db.Put(utils.GetTestKey(1), val1)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	t1 := time.Now()
+	time.Sleep(time.Millisecond * 5)
+	val2 := utils.RandomValue(10)
+	err = db.Put(utils.GetTestKey(1), val2)
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// merge 之后保留窗口内的历史版本依然可以读取
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	v, err := db.GetAt(utils.GetTestKey(1), t1)
+	assert.Nil(t, err)
+	assert.Equal(t, val1, v)
+	_, err = db.GetAt(utils.GetTestKey(2), t1)
+	assert.Nil(t, err)
+	_, err = db.GetAt(utils.GetTestKey(2), time.Now())
+	assert.Equal(t, ErrKeyNotFound, err)
+	v, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val2, v)
+	assert.Equal(t, 1, len(db.ListKeys()))
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 没有配置保留窗口时，merge 会清理掉历史版本
+	opts.MergeRetention = 0
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = db.GetAt(utils.GetTestKey(1), t1)
+	assert.Equal(t, ErrKeyNotFound, err)
+	v, err = db.GetAt(utils.GetTestKey(1), time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, val2, v)
+}
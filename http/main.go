@@ -118,6 +118,8 @@ func main() {
 	http.HandleFunc("/bitcask/delete", handleDelete)
 	http.HandleFunc("/bitcask/listkeys", handleListKeys)
 	http.HandleFunc("/bitcask/stat", handleStat)
+	// Prometheus 文本格式的运行指标
+	http.Handle("/metrics", db.Metrics())
 
 	// 启动HTTP服务
 	_ = http.ListenAndServe("localhost:12260", nil)
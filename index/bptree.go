@@ -6,7 +6,8 @@ import (
 	"path/filepath"
 )
 
-const bptreeIndexFileName = "bptree-index"
+// BPTreeIndexFileName B+ 树索引的文件名称
+const BPTreeIndexFileName = "bptree-index"
 
 var indexBucketName = []byte("bitcask-index")
 
@@ -20,7 +21,7 @@ type BPlusTree struct {
 func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BPTreeIndexFileName), 0644, opts)
 	if err != nil {
 		panic("failed to open bptree")
 	}
@@ -36,6 +37,30 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	return &BPlusTree{tree: bptree}
 }
 
+// NewReadOnlyBPlusTree 以只读方式打开 B+ 树索引，多个进程可以同时打开
+// 索引文件或者其中的 bucket 不存在时返回错误
+func NewReadOnlyBPlusTree(dirPath string) (*BPlusTree, error) {
+	opts := *bbolt.DefaultOptions
+	opts.ReadOnly = true
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BPTreeIndexFileName), 0644, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// 只读模式下无法创建 bucket，只能检查是否存在
+	if err := bptree.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(indexBucketName) == nil {
+			return bbolt.ErrBucketNotFound
+		}
+		return nil
+	}); err != nil {
+		_ = bptree.Close()
+		return nil, err
+	}
+
+	return &BPlusTree{tree: bptree}, nil
+}
+
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	var oldVal []byte
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
@@ -107,3 +107,28 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestBPlusTree_ReadOnly(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-read-only")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	assert.Nil(t, tree.Close())
+
+	tree1, err := NewReadOnlyBPlusTree(path)
+	assert.Nil(t, err)
+	tree2, err := NewReadOnlyBPlusTree(path)
+	assert.Nil(t, err)
+	pos := tree1.Get([]byte("aac"))
+	assert.NotNil(t, pos)
+	assert.Equal(t, 1, tree2.Size())
+	assert.Nil(t, tree1.Close())
+	assert.Nil(t, tree2.Close())
+
+	// 索引文件不存在时返回错误
+	_, err = NewReadOnlyBPlusTree(filepath.Join(path, "not-exist"))
+	assert.NotNil(t, err)
+}
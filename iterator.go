@@ -3,6 +3,7 @@ package bitcask_go
 import (
 	"bitcask-go/index"
 	"bytes"
+	"time"
 )
 
 // Iterator 面向用户的迭代器
@@ -10,15 +11,26 @@ type Iterator struct {
 	indexIter index.Iterator // 索引迭代器
 	db        *DB
 	options   IteratorOptions
+	epoch     uint64 // 创建时数据文件的版本
+	closed    bool
 }
 
 // NewIterator 初始化迭代器
+// 迭代器使用完之后需要关闭，否则 merge 替换下来的数据文件无法删除
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
+	return db.newIterator(db.index, opts)
+}
+
+// 初始化指定索引的迭代器
+func (db *DB) newIterator(idx index.Indexer, opts IteratorOptions) *Iterator {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	indexIter := idx.Iterator(opts.Reverse)
 	return &Iterator{
 		db:        db,
 		indexIter: indexIter,
 		options:   opts,
+		epoch:     db.epochs.acquire(),
 	}
 }
 
@@ -56,24 +68,30 @@ func (it *Iterator) Value() ([]byte, error) {
 	logRecordPos := it.indexIter.Value()
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
-	return it.db.getValueByPosition(logRecordPos)
+	return it.db.getValueAt(logRecordPos, it.epoch, DefaultReadOptions)
 
 }
 
 // Close 关闭迭代器，释放相应资源
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if !it.closed {
+		it.closed = true
+		it.db.epochs.release(it.epoch)
+	}
 }
 
+// 跳过前缀不匹配以及已经过期的 key
 func (it *Iterator) skipToNext() {
 	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
-	}
+	now := time.Now().UnixNano()
 
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		if it.indexIter.Value().IsExpired(now) {
+			continue
+		}
 		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
+		if prefixLen == 0 || (prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0) {
 			break
 		}
 	}
@@ -0,0 +1,182 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// 单个数据文件解码之后的记录
+type decodedFile struct {
+	records   []*data.TransactionRecord
+	compacted bool           // 记录来自选择性 merge 生成的 hint 文件，key 中不带有事务序列号
+	skipped   bool           // 已经从 merge 生成的 hint-index 中加载了索引
+	size      int64          // 数据文件的大小
+	offset    int64          // 读取结束的位置
+	recovery  RecoveryReport // 按照恢复策略丢弃的数据
+	err       error
+}
+
+// 并发解码数据文件，按照文件 id 的顺序依次取出解码的结果
+// 正在解码以及等待更新到索引中的文件不超过 Options.IndexLoadConcurrency 个，避免解码的结果占用过多的内存
+type dataFileLoader struct {
+	results    []chan *decodedFile
+	sem        chan struct{}
+	stopCh     chan struct{}
+	wg         *sync.WaitGroup
+	index      int   // 下一个取出结果的文件
+	totalBytes int64 // 所有数据文件的大小
+}
+
+func (db *DB) newDataFileLoader(hasMerge bool, nonMergeFileId uint32) (*dataFileLoader, error) {
+	concurrency := db.options.IndexLoadConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	dataFiles := make([]*data.DataFile, len(db.fileIds))
+	loader := &dataFileLoader{
+		results: make([]chan *decodedFile, len(db.fileIds)),
+		sem:     make(chan struct{}, concurrency),
+		stopCh:  make(chan struct{}),
+		wg:      new(sync.WaitGroup),
+	}
+	for i, fid := range db.fileIds {
+		if i == len(db.fileIds)-1 {
+			dataFiles[i] = db.activeFile
+		} else {
+			dataFiles[i] = db.olderFiles[uint32(fid)]
+		}
+		size, err := dataFiles[i].IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		loader.totalBytes += size
+		loader.results[i] = make(chan *decodedFile, 1)
+	}
+
+	loader.wg.Add(1)
+	go func() {
+		defer loader.wg.Done()
+		for i, dataFile := range dataFiles {
+			select {
+			case loader.sem <- struct{}{}:
+			case <-loader.stopCh:
+				return
+			}
+			loader.wg.Add(1)
+			go func(i int, dataFile *data.DataFile) {
+				defer loader.wg.Done()
+				isActive := i == len(dataFiles)-1
+				loader.results[i] <- db.decodeDataFile(dataFile, isActive, hasMerge && dataFile.FileId < nonMergeFileId)
+			}(i, dataFile)
+		}
+	}()
+	return loader, nil
+}
+
+// 取出下一个文件的解码结果，并允许开始解码新的文件
+func (l *dataFileLoader) next() *decodedFile {
+	file := <-l.results[l.index]
+	l.index++
+	<-l.sem
+	return file
+}
+
+// 停止解码，等待正在解码的文件完成，之后才能关闭数据文件
+func (l *dataFileLoader) stop() {
+	close(l.stopCh)
+	l.wg.Wait()
+}
+
+// 解码数据文件中的所有记录，有完整的 hint 文件时从 hint 文件中读取，只扫描 hint 没有覆盖的数据
+// merged 表示数据文件是 merge 生成的，已经从 hint-index 中加载了索引
+// 在独立的协程中执行，解码中的 panic 转换为错误，由 Open 返回给调用者
+func (db *DB) decodeDataFile(dataFile *data.DataFile, isActive, merged bool) (file *decodedFile) {
+	defer func() {
+		if r := recover(); r != nil {
+			file = &decodedFile{err: fmt.Errorf("%w: data file %d: %v", ErrDecodePanicked, dataFile.FileId, r)}
+		}
+	}()
+	file = &decodedFile{}
+	file.size, file.err = dataFile.IoManager.Size()
+	if file.err != nil {
+		return file
+	}
+
+	var hint *fileHint
+	if _, ok := db.hintFileIds[dataFile.FileId]; ok {
+		hint = db.readFileHint(dataFile)
+	} else if merged {
+		file.skipped = true
+		return file
+	}
+	var offset int64 = 0
+	if hint != nil {
+		file.records = hint.records
+		file.compacted = !hint.sealed
+		if file.compacted {
+			return file
+		}
+		offset = hint.size
+	}
+
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			// 数据损坏时按照恢复策略处理
+			next, err := db.recoverDataFile(&file.recovery, dataFile, isActive, offset, err)
+			if err == io.EOF {
+				offset = next
+				break
+			}
+			if err != nil {
+				file.err = err
+				return file
+			}
+			offset = next
+			continue
+		}
+
+		// 构造内存索引
+		logRecordPos := &data.LogRecordPos{
+			Fid:    dataFile.FileId,
+			Offset: offset,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		}
+		if logRecord.BlobRef {
+			blobPos := data.DecodeBlobPos(logRecord.Value)
+			logRecordPos.BlobFid = blobPos.Fid
+			logRecordPos.BlobSize = blobPos.Size
+		}
+		// 只有范围删除的终点需要保留 value
+		var value []byte
+		if logRecord.Type == data.LogRecordRangeDeleted {
+			value = logRecord.Value
+		}
+		file.records = append(file.records, &data.TransactionRecord{
+			Record: &data.LogRecord{
+				Key:       logRecord.Key,
+				Value:     value,
+				Type:      logRecord.Type,
+				Namespace: logRecord.Namespace,
+			},
+			Pos: logRecordPos,
+		})
+
+		// 递增 offset，下一次从新的位置开始读取
+		offset += size
+	}
+	file.offset = offset
+
+	// 没有 hint 文件的旧数据文件重新生成 hint 文件，下一次启动时不需要扫描
+	if hint == nil && !isActive && db.fileHints && len(file.recovery.Discarded) == 0 {
+		db.writeFileHint(dataFile.FileId, file.records, offset)
+	}
+	return file
+}
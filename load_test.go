@@ -0,0 +1,203 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_LoadIndex_Parallel(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-load-parallel")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 同一个 key 在不同的数据文件中多次写入，以最后一次写入为准
+	values := make(map[string][]byte)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 200; i++ {
+			key := utils.GetTestKey(i)
+			values[string(key)] = utils.RandomValue(128)
+			err := db.Put(key, values[string(key)])
+			assert.Nil(t, err)
+		}
+	}
+	// 跨越多个数据文件的事务
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 200; i < 400; i++ {
+		key := utils.GetTestKey(i)
+		values[string(key)] = utils.RandomValue(128)
+		_ = wb.Put(key, values[string(key)])
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 0; i < 100; i += 3 {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, string(utils.GetTestKey(i)))
+	}
+	seqNo := db.seqNo
+	lastFileId := db.activeFile.FileId
+	assert.True(t, lastFileId > 8)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	check := func(concurrency int) {
+		var progress []IndexLoadProgress
+		opts.IndexLoadConcurrency = concurrency
+		opts.OnIndexLoadProgress = func(p IndexLoadProgress) {
+			progress = append(progress, p)
+		}
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, len(values), len(db.ListKeys()))
+		for key, value := range values {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		assert.Equal(t, seqNo, db.seqNo)
+		err = db.Close()
+		assert.Nil(t, err)
+
+		assert.Equal(t, int(lastFileId)+1, len(progress))
+		for i, p := range progress {
+			assert.Equal(t, i+1, p.FilesLoaded)
+			assert.Equal(t, len(progress), p.TotalFiles)
+			if i > 0 {
+				assert.True(t, p.BytesLoaded > progress[i-1].BytesLoaded)
+			}
+		}
+		last := progress[len(progress)-1]
+		assert.Equal(t, last.TotalBytes, last.BytesLoaded)
+	}
+	// 从 hint 文件中加载
+	check(1)
+	check(4)
+
+	// 没有 hint 文件时并发扫描数据文件
+	removeHints := func() {
+		for fileId := uint32(0); fileId <= lastFileId; fileId++ {
+			assert.Nil(t, os.Remove(data.GetHintFileName(dir, fileId)))
+		}
+	}
+	removeHints()
+	check(4)
+	removeHints()
+	check(1)
+
+	opts.IndexLoadConcurrency = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_LoadIndex_ParallelRecovery(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-load-parallel-recovery")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 破坏多个旧数据文件中的数据，删除 hint 文件之后启动时才会扫描
+	corrupted := []uint32{1, 3, 5}
+	for _, fileId := range corrupted {
+		assert.Nil(t, os.Remove(data.GetHintFileName(dir, fileId)))
+		file, err := os.OpenFile(data.GetDataFileName(dir, fileId), os.O_RDWR, 0644)
+		assert.Nil(t, err)
+		_, err = file.WriteAt([]byte("corrupted"), 1024)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+	}
+
+	opts.IndexLoadConcurrency = 4
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+
+	// 丢弃的数据按照加载的顺序排列
+	opts.RecoveryPolicy = RecoverySkipCorrupt
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	report := db.RecoveryReport()
+	assert.Equal(t, len(corrupted), len(report.Discarded))
+	for i, discarded := range report.Discarded {
+		assert.Equal(t, corrupted[i], discarded.FileId)
+	}
+	keys := len(db.ListKeys())
+	assert.True(t, keys < 1000 && keys >= 994)
+}
+
+// 解码时会 panic 的压缩算法，用于模拟解码过程中的异常
+type panickingCodec struct {
+	panicking bool
+}
+
+func (c *panickingCodec) Type() data.CodecType {
+	return 102
+}
+
+func (c *panickingCodec) Compress(src []byte) ([]byte, error) {
+	return src[:len(src)/2], nil
+}
+
+func (c *panickingCodec) Decompress(src []byte) ([]byte, error) {
+	if c.panicking {
+		panic("decompress panicked")
+	}
+	return append(src, src...), nil
+}
+
+func TestDB_LoadIndex_Panic(t *testing.T) {
+	codec := &panickingCodec{}
+	data.RegisterCodec(codec)
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-load-panic")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.Compression = codec.Type()
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 解码协程中的 panic 不会导致进程退出，Open 返回错误
+	for fileId := uint32(0); fileId <= db.activeFile.FileId; fileId++ {
+		_ = os.Remove(data.GetHintFileName(dir, fileId))
+	}
+	codec.panicking = true
+	opts.IndexLoadConcurrency = 4
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDecodePanicked))
+
+	codec.panicking = false
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+}
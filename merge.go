@@ -2,6 +2,8 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
 	"bitcask-go/utils"
 	"io"
 	"os"
@@ -9,46 +11,68 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"time"
 )
 
 const (
-	mergeDirName     = "-merge"
-	mergeFinishedKey = "merge.finished"
+	mergeDirName      = "-merge"
+	mergeFinishedKey  = "merge.finished"
+	mergeFirstFileKey = "merge.first"
 )
 
+// merge 过程中收集的索引信息
+type mergeIndexEntry struct {
+	nsId uint32
+	key  []byte
+	pos  *data.LogRecordPos
+}
+
 // Merge 清理无效数据，生成 Hint 文件
+// merge 完成之后会在线替换数据文件并更新内存索引，被替换下来的数据文件在使用它们的迭代器和事务结束之后删除
 func (db *DB) Merge() error {
+	_, err := db.merge()
+	return err
+}
+
+// 执行 merge，返回实际回收的数据量
+func (db *DB) merge() (reclaimed int64, err error) {
+	start := time.Now()
+	if db.options.ReadOnly {
+		return 0, ErrDatabaseIsReadOnly
+	}
 	// 如果数据库为空，则直接返回
 	if db.activeFile == nil {
-		return nil
+		return 0, nil
 	}
 	db.mu.Lock()
 	// 如果 merge 正在进行当中，则直接返回
 	if db.isMerging {
 		db.mu.Unlock()
-		return ErrMergeIsProgress
+		return 0, ErrMergeIsProgress
 	}
 
 	// 查看可以 merge 的数据量是否达到了阈值
 	totalSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
 		db.mu.Unlock()
-		return err
+		return 0, err
 	}
+	// blob 文件不参与 merge
+	totalSize -= db.blobFilesSize()
 	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
-		return ErrMergeRatioUnreached
+		return 0, ErrMergeRatioUnreached
 	}
 
 	// 查看剩余的空间容量是否可以容纳 merge 之后的数据量
 	availableDiskSize, err := utils.AvailableDiskSize()
 	if err != nil {
 		db.mu.Unlock()
-		return err
+		return 0, err
 	}
 	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
 		db.mu.Unlock()
-		return ErrNoEnoughSpaceForMerge
+		return 0, ErrNoEnoughSpaceForMerge
 	}
 
 	db.isMerging = true
@@ -56,61 +80,146 @@ func (db *DB) Merge() error {
 		db.isMerging = false
 	}()
 
+	// 先写入合并操作数合并之后的值，被重写的数据文件中不会留下未合并的操作数
+	if err := db.foldMergeOperands(); err != nil {
+		db.mu.Unlock()
+		return 0, err
+	}
 	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
+	if err := db.syncActiveFiles(); err != nil {
 		db.mu.Unlock()
-		return err
+		return 0, err
 	}
 	// 将当前活跃文件转换为旧的数据文件
 	db.olderFiles[db.activeFile.FileId] = db.activeFile
-	// 打开新的活跃文件
-	if err := db.setActiveDataFile(); err != nil {
-		db.mu.Unlock()
-		return nil
-	}
-	// 记录最近没有参与 merge 的文件 id
-	nonMergeFileId := db.activeFile.FileId
 
 	// 取出所有需要 merge 的文件
 	var mergeFiles []*data.DataFile
 	for _, file := range db.olderFiles {
 		mergeFiles = append(mergeFiles, file)
 	}
+
+	// merge 之后的数据文件使用新的文件 id，不会和正在使用的数据文件冲突
+	// 预留和参与 merge 的文件数量相同的文件 id，重新压缩或加密之后数据量变大时，超出的数据写到最后一个预留的文件中
+	firstMergeFileId := db.activeFile.FileId + 1
+	// 记录最近没有参与 merge 的文件 id
+	nonMergeFileId := firstMergeFileId + uint32(len(mergeFiles))
+	// 打开新的活跃文件
+	if err := db.openActiveDataFile(nonMergeFileId); err != nil {
+		db.mu.Unlock()
+		return 0, err
+	}
+	// merge 期间可能会创建新的 namespace，不持有锁时只能使用复制的索引
+	indexes := db.copyIndexes()
 	db.mu.Unlock()
 
 	//	待 merge 的文件从小到大进行排序，依次 merge
 	sort.Slice(mergeFiles, func(i, j int) bool {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
 	})
+	fileIds := make([]uint32, 0, len(mergeFiles))
+	for _, file := range mergeFiles {
+		fileIds = append(fileIds, file.FileId)
+	}
+
+	beginInfo := MergeBeginInfo{FileIds: fileIds}
+	db.events.post(func(l EventListener) {
+		l.OnMergeBegin(beginInfo)
+	})
+	defer func() {
+		endInfo := MergeEndInfo{MergeBeginInfo: beginInfo, Duration: time.Since(start), ReclaimedBytes: reclaimed, Err: err}
+		db.events.post(func(l EventListener) {
+			l.OnMergeEnd(endInfo)
+		})
+	}()
+
+	expiredEntries, err := db.writeMergeFiles(mergeFiles, indexes, firstMergeFileId, nonMergeFileId)
+	if err != nil {
+		return 0, err
+	}
+	// 超出预留范围的数据文件会和新的活跃文件冲突，放弃本次 merge，避免下一次启动时移动到数据目录中
+	if _, err := os.Stat(data.GetDataFileName(db.getMergePath(), nonMergeFileId)); err == nil {
+		_ = os.RemoveAll(db.getMergePath())
+		return 0, ErrMergeFileIdOverflow
+	}
+
+	// 回收的空间为参与 merge 的数据文件和重写之后的数据文件的大小之差
+	newFileIds := make([]uint32, 0, len(mergeFiles))
+	for fileId := firstMergeFileId; fileId < nonMergeFileId; fileId++ {
+		newFileIds = append(newFileIds, fileId)
+	}
+	mergedSize := dataFilesSize(db.options.DirPath, fileIds) - dataFilesSize(db.getMergePath(), newFileIds)
 
+	if err := db.swapMergeFiles(mergeFiles, expiredEntries, firstMergeFileId, nonMergeFileId); err != nil {
+		return 0, err
+	}
+	db.metrics.observeMerge(start, mergedSize)
+	return mergedSize, nil
+}
+
+// 将有效数据重写到 merge 目录中，生成 hint 文件和标识 merge 完成的文件
+// 返回已经过期但仍在索引中的数据，替换数据文件时需要从索引中删除
+func (db *DB) writeMergeFiles(mergeFiles []*data.DataFile, indexes map[uint32]index.Indexer, firstMergeFileId, nonMergeFileId uint32) ([]*mergeIndexEntry, error) {
 	mergePath := db.getMergePath()
 	// 如果目录存在，说明发生过 merge，将其删除掉
 	if _, err := os.Stat(mergePath); err == nil {
 		if err := os.RemoveAll(mergePath); err != nil {
-			return err
+			return nil, err
 		}
 	}
 	// 新建一个 merge path 的目录
 	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
-		return err
+		return nil, err
 	}
 	// 打开一个新的临时 bitcask 实例
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	// 数据文件中已经是 blob 位置，临时实例不能生成 blob 文件
+	mergeOptions.ValueThreshold = 0
+	mergeOptions.AutoMergeInterval = 0
+	mergeOptions.EventListener = nil
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer mergeDB.Close()
+	// merge 之后的数据文件通过 hint-index 加载索引，不需要生成独立的 hint 文件
+	mergeDB.fileHints = false
+
+	// 从预留的文件 id 开始写入，不能超出预留的范围
+	if err := mergeDB.openActiveDataFile(firstMergeFileId); err != nil {
+		return nil, err
+	}
+	mergeDB.lastFileId = nonMergeFileId - 1
 
 	// 打开 hint 文件存储索引
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer hintFile.Close()
+	hintFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
+	// 重写数据，写入的数据量计入后台 IO 的速率限制
+	appendRecord := func(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+		pos, err := mergeDB.appendRewrittenRecord(logRecord, false)
+		if err != nil {
+			return nil, err
+		}
+		db.bgLimiter.Wait(int64(pos.Size))
+		return pos, nil
 	}
 	// 遍历处理每个数据文件
+	now := time.Now().UnixNano()
+	// 写入时间在这之后的历史版本需要保留
+	var retainAfter int64 = -1
+	if db.options.MergeRetention > 0 {
+		retainAfter = now - db.options.MergeRetention.Nanoseconds()
+	}
+	// 暂存需要保留的历史事务数据，事务完成之后才会重写
+	historyTxnRecords := make(map[uint64][]*data.LogRecord)
+	var expiredEntries []*mergeIndexEntry
 	for _, dataFile := range mergeFiles {
 		var offset int64 = 0
 		for {
@@ -119,24 +228,64 @@ func (db *DB) Merge() error {
 				if err == io.EOF {
 					break
 				}
-				return err
+				// 跳过启动时已经按照恢复策略丢弃的损坏数据
+				next, err := db.skipCorruptRecord(dataFile, offset, err)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				offset = next
+				continue
 			}
+			db.bgLimiter.Wait(size)
 			// 解析拿到实际的 key
-			realKey, _ := parseLogRecordKey(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
-			// 和内存中的索引位置进行比较，如果有效则重写
-			if logRecordPos != nil &&
+			realKey, seqNo := parseLogRecordKey(logRecord.Key)
+			var logRecordPos *data.LogRecordPos
+			if idx, ok := indexes[logRecord.Namespace]; ok {
+				logRecordPos = idx.Get(realKey)
+			}
+			isLive := logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileId &&
-				logRecordPos.Offset == offset {
+				logRecordPos.Offset == offset
+			if isLive && logRecordPos.IsExpired(now) {
+				expiredEntries = append(expiredEntries, &mergeIndexEntry{
+					nsId: logRecord.Namespace,
+					key:  realKey,
+					pos:  logRecordPos,
+				})
+			}
+			// 和内存中的索引位置进行比较，如果有效并且没有过期则重写
+			if isLive && !logRecordPos.IsExpired(now) {
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
-				pos, err := mergeDB.appendLogRecord(logRecord)
+				pos, err := appendRecord(logRecord)
 				if err != nil {
-					return err
+					return nil, err
 				}
 				// 将当前位置索引写到 Hint 文件当中
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
-					return err
+				if err := hintFile.WriteHintRecord(realKey, logRecord.Namespace, pos); err != nil {
+					return nil, err
+				}
+			} else if retainAfter >= 0 && logRecord.Timestamp >= retainAfter {
+				// 保留窗口内的历史版本，只有已经提交的事务数据才会保留
+				if logRecord.Type == data.LogRecordTxnFinished {
+					for _, record := range historyTxnRecords[seqNo] {
+						if _, err := appendRecord(record); err != nil {
+							return nil, err
+						}
+					}
+					delete(historyTxnRecords, seqNo)
+				} else {
+					logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+					if seqNo == nonTransactionSeqNo {
+						if _, err := appendRecord(logRecord); err != nil {
+							return nil, err
+						}
+					} else {
+						historyTxnRecords[seqNo] = append(historyTxnRecords[seqNo], logRecord)
+					}
 				}
 			}
 			// 增加 offset
@@ -146,30 +295,174 @@ func (db *DB) Merge() error {
 
 	// sync 保证持久化
 	if err := hintFile.Sync(); err != nil {
-		return err
+		return nil, err
 	}
 	if err := mergeDB.Sync(); err != nil {
-		return err
+		return nil, err
 	}
 
 	// 写标识 merge 完成的文件
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	mergeFinRecord := &data.LogRecord{
-		Key:   []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
+	defer mergeFinishedFile.Close()
+	mergeFinRecords := []*data.LogRecord{
+		{Key: []byte(mergeFinishedKey), Value: []byte(strconv.Itoa(int(nonMergeFileId)))},
+		{Key: []byte(mergeFirstFileKey), Value: []byte(strconv.Itoa(int(firstMergeFileId)))},
 	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
-		return err
+	for _, record := range mergeFinRecords {
+		encRecord, _ := data.EncodeLogRecord(record)
+		if err := mergeFinishedFile.Write(encRecord); err != nil {
+			return nil, err
+		}
 	}
 	if err := mergeFinishedFile.Sync(); err != nil {
+		return nil, err
+	}
+
+	return expiredEntries, nil
+}
+
+// 在线替换数据文件，将 merge 之后的数据文件移动到数据目录中，并更新内存索引
+// 标识 merge 完成的文件最后移动，中途失败的话下一次启动时会在 loadMergeFiles 中继续处理
+func (db *DB) swapMergeFiles(mergeFiles []*data.DataFile, expiredEntries []*mergeIndexEntry,
+	firstMergeFileId, nonMergeFileId uint32) error {
+	mergePath := db.getMergePath()
+	// 读取 merge 之后的索引位置
+	hintEntries, err := db.readMergeHintFile(mergePath)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 选择性 merge 还没有生效的结果已经被全量 merge 覆盖
+	if err := os.RemoveAll(db.getCompactPath()); err != nil {
+		return err
+	}
+
+	// 移动并打开 merge 之后的数据文件
+	newFiles := make(map[uint32]*data.DataFile)
+	closeNewFiles := func() {
+		for _, file := range newFiles {
+			_ = file.Close()
+		}
+	}
+	for fileId := firstMergeFileId; fileId < nonMergeFileId; fileId++ {
+		srcPath := data.GetDataFileName(mergePath, fileId)
+		if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+			break
+		}
+		if err := os.Rename(srcPath, data.GetDataFileName(db.options.DirPath, fileId)); err != nil {
+			closeNewFiles()
+			return err
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, fileId, fio.StandardFIO)
+		if err != nil {
+			closeNewFiles()
+			return err
+		}
+		dataFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
+		newFiles[fileId] = dataFile
+	}
+
+	// 更新内存索引，merge 期间被修改过的 key 已经指向新的数据文件，不需要更新
+	replaced := make(map[uint32]struct{}, len(mergeFiles))
+	for _, file := range mergeFiles {
+		replaced[file.FileId] = struct{}{}
+	}
+	for _, entry := range hintEntries {
+		idx := db.indexOf(entry.nsId)
+		if idx == nil {
+			continue
+		}
+		if pos := idx.Get(entry.key); pos != nil {
+			if _, ok := replaced[pos.Fid]; ok {
+				idx.Put(entry.key, entry.pos)
+			}
+		}
+	}
+	// 已经过期的数据没有被重写，从索引中删除
+	for _, entry := range expiredEntries {
+		idx := db.indexOf(entry.nsId)
+		if idx == nil {
+			continue
+		}
+		pos := idx.Get(entry.key)
+		if pos == nil || pos.Fid != entry.pos.Fid || pos.Offset != entry.pos.Offset {
+			continue
+		}
+		idx.Delete(entry.key)
+		if pos.BlobSize > 0 {
+			db.blobReclaimSize[pos.BlobFid] += int64(pos.BlobSize)
+		}
+	}
+
+	// 替换数据文件，旧的数据文件中的无效数据已经被回收
+	for _, file := range mergeFiles {
+		delete(db.olderFiles, file.FileId)
+		db.dropFileReclaimSize(file.FileId)
+		db.dropMergeOperands(file.FileId)
+		hintFileName := data.GetHintFileName(db.options.DirPath, file.FileId)
+		if err := os.Remove(hintFileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for fileId, file := range newFiles {
+		db.olderFiles[fileId] = file
+	}
+	db.epochs.retire(mergeFiles, false)
+
+	// 移动 hint 文件，最后移动标识 merge 完成的文件
+	for _, fileName := range []string{data.HintFileName, data.MergeFinishedFileName} {
+		srcPath := filepath.Join(mergePath, fileName)
+		if err := os.Rename(srcPath, filepath.Join(db.options.DirPath, fileName)); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(mergePath)
+}
+
+// 读取 merge 目录中 hint 文件的全部索引信息
+func (db *DB) readMergeHintFile(mergePath string) ([]*mergeIndexEntry, error) {
+	hintFile, err := data.OpenHintFile(mergePath)
+	if err != nil {
+		return nil, err
+	}
+	defer hintFile.Close()
+	hintFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
+
+	var entries []*mergeIndexEntry
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		entries = append(entries, &mergeIndexEntry{
+			nsId: logRecord.Namespace,
+			key:  logRecord.Key,
+			pos:  data.DecodeLogRecordPos(logRecord.Value),
+		})
+		offset += size
+	}
+	return entries, nil
+}
+
+// 移除数据文件中无效数据的统计，包括所有 namespace 中的统计
+// 在访问此方法前必须持有互斥锁
+func (db *DB) dropFileReclaimSize(fileId uint32) {
+	db.reclaimSize -= db.fileReclaimSize[fileId]
+	delete(db.fileReclaimSize, fileId)
+	for _, ns := range db.namespaces {
+		ns.reclaimSize -= ns.fileReclaimSize[fileId]
+		delete(ns.fileReclaimSize, fileId)
+	}
 }
 
 func (db *DB) getMergePath() string {
@@ -178,8 +471,16 @@ func (db *DB) getMergePath() string {
 	return filepath.Join(dir, base+mergeDirName)
 }
 
-// 加载 merge 数据目录
+// 加载 merge 数据目录，并删除已经被 merge 替换掉的旧数据文件
 func (db *DB) loadMergeFiles() error {
+	if err := db.moveMergeFiles(); err != nil {
+		return err
+	}
+	return db.removeReplacedFiles()
+}
+
+// 将 merge 目录中的文件移动到数据目录中
+func (db *DB) moveMergeFiles() error {
 	mergePath := db.getMergePath()
 	// merge 目录不存在的话直接返回
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
@@ -204,7 +505,7 @@ func (db *DB) loadMergeFiles() error {
 		if entry.Name() == data.SeqNoFileName {
 			continue
 		}
-		if entry.Name() == fileLockName {
+		if entry.Name() == fileLockName || entry.Name() == writerFileLockName {
 			continue
 		}
 		mergeFileNames = append(mergeFileNames, entry.Name())
@@ -219,16 +520,19 @@ func (db *DB) loadMergeFiles() error {
 	if err != nil {
 		return nil
 	}
+	// 旧版本的 merge 之后的数据文件从 0 开始，需要删除 nonMergeFileId 之前所有的数据文件
+	firstMergeFileId, err := db.getFirstMergeFileId(mergePath)
+	if err != nil {
+		return nil
+	}
+	if firstMergeFileId == 0 {
+		firstMergeFileId = nonMergeFileId
+	}
 
-	// 删除旧的数据文件
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
-			}
-		}
+	// 删除旧的数据文件，以及选择性 merge 生成的 hint 文件
+	// 在线替换中途失败时，已经移动过的数据文件不在删除的范围内
+	if err := db.removeDataFilesBefore(firstMergeFileId); err != nil {
+		return err
 	}
 
 	// 将新的数据文件移动到数据目录中
@@ -242,6 +546,39 @@ func (db *DB) loadMergeFiles() error {
 	return nil
 }
 
+// 删除已经被 merge 替换掉的旧数据文件
+// 在线替换之后旧的数据文件会在读取者结束之后删除，数据库在此之前退出的话在启动时删除
+func (db *DB) removeReplacedFiles() error {
+	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); os.IsNotExist(err) {
+		return nil
+	}
+	firstMergeFileId, err := db.getFirstMergeFileId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	return db.removeDataFilesBefore(firstMergeFileId)
+}
+
+// 删除 id 小于指定值的数据文件，以及对应的 hint 文件
+func (db *DB) removeDataFilesBefore(fileId uint32) error {
+	var fid uint32 = 0
+	for ; fid < fileId; fid++ {
+		fileNames := []string{
+			data.GetDataFileName(db.options.DirPath, fid),
+			data.GetHintFileName(db.options.DirPath, fid),
+		}
+		for _, fileName := range fileNames {
+			if _, err := os.Stat(fileName); err == nil {
+				if err := os.Remove(fileName); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
@@ -258,6 +595,33 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	return uint32(nonMergeFileId), nil
 }
 
+// 获取 merge 之后第一个数据文件的 id，旧版本的 merge 没有记录，返回 0
+func (db *DB) getFirstMergeFileId(dirPath string) (uint32, error) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	defer mergeFinishedFile.Close()
+	var offset int64 = 0
+	for {
+		record, size, err := mergeFinishedFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if string(record.Key) == mergeFirstFileKey {
+			firstMergeFileId, err := strconv.Atoi(string(record.Value))
+			if err != nil {
+				return 0, err
+			}
+			return uint32(firstMergeFileId), nil
+		}
+		offset += size
+	}
+}
+
 // 从 hint 文件中加载索引
 func (db *DB) loadIndexFromHintFile() error {
 	// 查看 hint 索引文件是否存在
@@ -271,9 +635,11 @@ func (db *DB) loadIndexFromHintFile() error {
 	if err != nil {
 		return err
 	}
+	hintFile.SetEncryptor(db.options.Encryption, db.options.EncryptKeys)
 
 	// 读取文件中的索引
 	var offset int64 = 0
+	now := time.Now().UnixNano()
 	for {
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
@@ -285,8 +651,27 @@ func (db *DB) loadIndexFromHintFile() error {
 
 		// 解码拿到实际的位置索引
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		idx := db.indexOf(logRecord.Namespace)
+		if idx == nil {
+			return ErrDataDirectoryCorrupted
+		}
+		// 已经过期的数据不需要加载到索引中
+		// 选择性 merge 重写过的数据文件，从其独立的 hint 文件中加载索引
+		if _, ok := db.hintFileIds[pos.Fid]; !ok && !pos.IsExpired(now) {
+			idx.Put(logRecord.Key, pos)
+		}
 		offset += size
 	}
 	return nil
 }
+
+// 目录中指定的数据文件的大小之和，不存在的文件忽略
+func dataFilesSize(dirPath string, fileIds []uint32) int64 {
+	var size int64
+	for _, fileId := range fileIds {
+		if info, err := os.Stat(data.GetDataFileName(dirPath, fileId)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
@@ -1,11 +1,14 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"sync"
 	"testing"
+	"time"
 )
 
 // 没有任何数据的情况下进行 merge
@@ -203,3 +206,238 @@ func TestDB_Merge5(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// 过期的数据在 merge 时会被清理掉
+func TestDB_Merge_Expired(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-expired")
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(1024), time.Millisecond*100)
+		assert.Nil(t, err)
+	}
+	for i := 10000; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	// 过期的数据在 merge 之后直接从索引中删除
+	assert.Equal(t, 10000, db.index.Size())
+
+	// 重启校验
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, db2.index.Size())
+	keys := db2.ListKeys()
+	assert.Equal(t, 10000, len(keys))
+}
+
+// merge 之后在线替换数据文件，进行中的迭代器和事务依然可以读取旧的数据文件
+func TestDB_Merge_Online(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-online")
+	opts.DataFileSize = 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 10000; i++ {
+		key := utils.GetTestKey(i)
+		values[string(key)] = utils.RandomValue(128)
+		err := db.Put(key, values[string(key)])
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 5000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, string(utils.GetTestKey(i)))
+	}
+
+	// merge 之前打开的迭代器和事务
+	iter := db.NewIterator(DefaultIteratorOptions)
+	txn, err := db.Begin(true)
+	assert.Nil(t, err)
+	txnIter, err := txn.Iterator(DefaultIteratorOptions)
+	assert.Nil(t, err)
+	var oldFileIds []uint32
+	for fid := range db.olderFiles {
+		oldFileIds = append(oldFileIds, fid)
+	}
+	oldFileIds = append(oldFileIds, db.activeFile.FileId)
+	diskSize := db.Stat().DiskSize
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	// merge 目录已经移动到数据目录中
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+	assert.Equal(t, 5000, len(db.ListKeys()))
+	for key, value := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	// 旧的数据文件在迭代器和事务结束之前依然存在
+	count := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, values[string(iter.Key())], val)
+		count++
+	}
+	assert.Equal(t, 5000, count)
+	for txnIter.Rewind(); txnIter.Valid(); txnIter.Next() {
+		val, err := txnIter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, values[string(txnIter.Key())], val)
+	}
+	iter.Close()
+	txnIter.Close()
+	for _, fid := range oldFileIds {
+		_, err := os.Stat(data.GetDataFileName(dir, fid))
+		assert.Nil(t, err)
+	}
+
+	// 所有的读取者结束之后删除旧的数据文件
+	txn.Rollback()
+	for _, fid := range oldFileIds {
+		_, err := os.Stat(data.GetDataFileName(dir, fid))
+		assert.True(t, os.IsNotExist(err))
+	}
+	assert.Less(t, db.Stat().DiskSize, diskSize)
+
+	// merge 之后继续写入，重启校验
+	for i := 10000; i < 11000; i++ {
+		key := utils.GetTestKey(i)
+		values[string(key)] = utils.RandomValue(128)
+		err := db.Put(key, values[string(key)])
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), len(db2.ListKeys()))
+	for key, value := range values {
+		val, err := db2.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_Merge_RateLimit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-rate-limit")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.BackgroundIOBytesPerSec = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 128; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(512))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 64; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 读取约 64KB 并重写约 32KB 的数据
+	start := time.Now()
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= time.Second)
+
+	for i := 64; i < 128; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 运行期间调整速率之后 merge 不再等待
+	db.SetBackgroundRateLimit(0)
+	for i := 0; i < 64; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(512))
+		assert.Nil(t, err)
+	}
+	start = time.Now()
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestDB_Merge_OutputGrows(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-output-grows")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.Compression = GzipCompression
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 200; i++ {
+		values[i] = bytes.Repeat(utils.RandomValue(8), 128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 关闭压缩之后重写的数据量远大于原来的数据文件
+	opts.Compression = NoCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// merge 之后写入新的活跃文件，和重写的数据文件互不影响
+	values[1] = utils.RandomValue(128)
+	err = db.Put(utils.GetTestKey(1), values[1])
+	assert.Nil(t, err)
+
+	check := func(db *DB) {
+		for i := 0; i < 200; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, values[i], val)
+		}
+	}
+	check(db)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
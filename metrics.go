@@ -0,0 +1,118 @@
+package bitcask_go
+
+import (
+	"bitcask-go/metrics"
+	"bitcask-go/utils"
+	"time"
+)
+
+// 存储引擎的运行指标
+type dbMetrics struct {
+	registry *metrics.Registry
+
+	puts, gets, deletes, commits                         *metrics.Counter
+	putLatency, getLatency, deleteLatency, commitLatency *metrics.Histogram
+
+	bytesWritten *metrics.Counter
+	bytesRead    *metrics.Counter
+
+	syncs       *metrics.Counter
+	syncLatency *metrics.Histogram
+
+	rotations *metrics.Counter
+
+	merges         *metrics.Counter
+	mergeDuration  *metrics.Histogram
+	mergeReclaimed *metrics.Counter
+
+	indexLoadSeconds *metrics.Gauge
+}
+
+// merge 耗时的分布区间，单位为秒
+var mergeDurationBuckets = []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 600}
+
+func newDBMetrics(db *DB) *dbMetrics {
+	r := metrics.NewRegistry()
+	const (
+		opsName     = "bitcask_operations_total"
+		opsHelp     = "Number of operations."
+		latencyName = "bitcask_operation_duration_seconds"
+		latencyHelp = "Latency of operations in seconds."
+	)
+	m := &dbMetrics{
+		registry: r,
+
+		puts:          r.NewCounter(opsName, opsHelp, "op", "put"),
+		gets:          r.NewCounter(opsName, opsHelp, "op", "get"),
+		deletes:       r.NewCounter(opsName, opsHelp, "op", "delete"),
+		commits:       r.NewCounter(opsName, opsHelp, "op", "commit"),
+		putLatency:    r.NewHistogram(latencyName, latencyHelp, nil, "op", "put"),
+		getLatency:    r.NewHistogram(latencyName, latencyHelp, nil, "op", "get"),
+		deleteLatency: r.NewHistogram(latencyName, latencyHelp, nil, "op", "delete"),
+		commitLatency: r.NewHistogram(latencyName, latencyHelp, nil, "op", "commit"),
+
+		bytesWritten: r.NewCounter("bitcask_written_bytes_total", "Bytes appended to data and blob files."),
+		bytesRead:    r.NewCounter("bitcask_read_bytes_total", "Bytes read from data and blob files."),
+
+		syncs:       r.NewCounter("bitcask_fsync_total", "Number of fsync calls on active files."),
+		syncLatency: r.NewHistogram("bitcask_fsync_duration_seconds", "Latency of fsync calls in seconds.", nil),
+
+		rotations: r.NewCounter("bitcask_file_rotations_total", "Number of times the active data file was rotated."),
+
+		merges:         r.NewCounter("bitcask_merges_total", "Number of finished merges."),
+		mergeDuration:  r.NewHistogram("bitcask_merge_duration_seconds", "Duration of merges in seconds.", mergeDurationBuckets),
+		mergeReclaimed: r.NewCounter("bitcask_merge_reclaimed_bytes_total", "Bytes reclaimed by merges."),
+
+		indexLoadSeconds: r.NewGauge("bitcask_index_load_seconds", "Time spent loading the index on open in seconds."),
+	}
+
+	// 数据库的当前状态在输出时读取
+	r.NewGaugeFunc("bitcask_keys", "Number of keys in the default namespace.", func() float64 {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return float64(db.index.Size())
+	})
+	r.NewGaugeFunc("bitcask_data_files", "Number of data files.", func() float64 {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		num := len(db.olderFiles)
+		if db.activeFile != nil {
+			num++
+		}
+		return float64(num)
+	})
+	r.NewGaugeFunc("bitcask_reclaimable_bytes", "Bytes that can be reclaimed by merge.", func() float64 {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return float64(db.reclaimSize)
+	})
+	r.NewGaugeFunc("bitcask_disk_bytes", "Disk space used by the database directory.", func() float64 {
+		size, err := utils.DirSize(db.options.DirPath)
+		if err != nil {
+			return -1
+		}
+		return float64(size)
+	})
+	return m
+}
+
+// Metrics 返回数据库的运行指标，可以注册自定义的指标
+// 返回的注册表实现了 http.Handler，按照 Prometheus 文本格式输出所有的指标
+func (db *DB) Metrics() *metrics.Registry {
+	return db.metrics.registry
+}
+
+// 记录一次操作的次数和耗时
+func (m *dbMetrics) observe(ops *metrics.Counter, latency *metrics.Histogram, start time.Time) {
+	ops.Inc()
+	latency.ObserveSince(start)
+}
+
+// 记录一次完成的 merge
+func (m *dbMetrics) observeMerge(start time.Time, reclaimed int64) {
+	m.merges.Inc()
+	m.mergeDuration.ObserveSince(start)
+	if reclaimed > 0 {
+		m.mergeReclaimed.Add(uint64(reclaimed))
+	}
+}
@@ -0,0 +1,299 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets 默认的延迟分布区间，单位为秒，从 10 微秒到 1 秒
+var DefaultLatencyBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1,
+}
+
+type metricType = string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// Counter 单调递增的计数器
+type Counter struct {
+	value uint64
+}
+
+// Inc 计数加一
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add 计数增加 n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value 当前的计数
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Gauge 可以任意设置的数值
+type Gauge struct {
+	bits uint64
+}
+
+// Set 设置当前的数值
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+// Value 当前的数值
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Histogram 数值分布的统计，每个区间统计小于等于上界的数量
+type Histogram struct {
+	buckets []float64 // 各个区间的上界，从小到大排列
+	counts  []uint64  // 落在各个区间中的数量，不是累计值，最后一个为超过所有上界的数量
+	count   uint64
+	sumBits uint64
+}
+
+// Observe 记录一个数值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	for {
+		oldBits := atomic.LoadUint64(&h.sumBits)
+		newBits := math.Float64bits(math.Float64frombits(oldBits) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, oldBits, newBits) {
+			return
+		}
+	}
+}
+
+// ObserveSince 记录从 start 开始经过的时间，单位为秒
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Count 记录的数值的数量
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Sum 记录的数值的总和
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&h.sumBits))
+}
+
+// 一个指标，同名的指标使用不同的标签区分
+type metric struct {
+	labels string // 已经格式化好的标签，例如 op="put"
+	value  interface{}
+}
+
+// 同名指标的集合
+type family struct {
+	name    string
+	help    string
+	typ     metricType
+	metrics []*metric
+}
+
+// Registry 指标的注册表，按照 Prometheus 文本格式输出所有的指标
+// Registry 实现了 http.Handler，可以直接挂载到 HTTP 服务上供 Prometheus 抓取
+type Registry struct {
+	mu       *sync.RWMutex
+	families map[string]*family
+	names    []string // 按照注册顺序输出
+}
+
+// NewRegistry 创建一个空的注册表
+func NewRegistry() *Registry {
+	return &Registry{
+		mu:       new(sync.RWMutex),
+		families: make(map[string]*family),
+	}
+}
+
+// NewCounter 注册一个计数器，labels 为成对的标签名称和标签值
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := new(Counter)
+	r.register(name, help, typeCounter, labels, c)
+	return c
+}
+
+// NewGauge 注册一个数值
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := new(Gauge)
+	r.register(name, help, typeGauge, labels, g)
+	return g
+}
+
+// NewGaugeFunc 注册一个在输出时调用 fn 获取的数值
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64, labels ...string) {
+	r.register(name, help, typeGauge, labels, fn)
+}
+
+// NewHistogram 注册一个数值分布，buckets 为各个区间的上界，为空时使用 DefaultLatencyBuckets
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("buckets of histogram %s are not sorted", name))
+	}
+	h := &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+	r.register(name, help, typeHistogram, labels, h)
+	return h
+}
+
+// 注册指标，同名的指标类型必须相同，名称和标签都相同的指标不能重复注册
+func (r *Registry) register(name, help string, typ metricType, labels []string, value interface{}) {
+	if len(labels)%2 != 0 {
+		panic(fmt.Sprintf("labels of metric %s must be name and value pairs", name))
+	}
+	var sb strings.Builder
+	for i := 0; i < len(labels); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(labels[i])
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabelValue(labels[i+1]))
+		sb.WriteByte('"')
+	}
+	formatted := sb.String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: typ}
+		r.families[name] = f
+		r.names = append(r.names, name)
+	}
+	if f.typ != typ {
+		panic(fmt.Sprintf("metric %s is already registered as %s", name, f.typ))
+	}
+	for _, m := range f.metrics {
+		if m.labels == formatted {
+			panic(fmt.Sprintf("metric %s{%s} is already registered", name, formatted))
+		}
+	}
+	f.metrics = append(f.metrics, &metric{labels: formatted, value: value})
+}
+
+// WritePrometheus 按照 Prometheus 文本格式输出所有的指标
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.RLock()
+	families := make([]*family, 0, len(r.names))
+	for _, name := range r.names {
+		f := r.families[name]
+		families = append(families, &family{
+			name:    f.name,
+			help:    f.help,
+			typ:     f.typ,
+			metrics: append([]*metric(nil), f.metrics...),
+		})
+	}
+	r.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, m := range f.metrics {
+			switch v := m.value.(type) {
+			case *Counter:
+				writeSample(bw, f.name, m.labels, float64(v.Value()))
+			case *Gauge:
+				writeSample(bw, f.name, m.labels, v.Value())
+			case func() float64:
+				writeSample(bw, f.name, m.labels, v())
+			case *Histogram:
+				writeHistogram(bw, f.name, m.labels, v)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP 输出 Prometheus 文本格式的指标
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WritePrometheus(writer)
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *Histogram) {
+	// 先取出总数，保证各个区间的累计值不会超过总数
+	count := h.Count()
+	sum := h.Sum()
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		if cumulative > count {
+			cumulative = count
+		}
+		writeSample(w, name+"_bucket", joinLabels(labels, `le="`+formatFloat(upper)+`"`), float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(count))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+func writeSample(w io.Writer, name, labels string, value float64) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(value))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+	}
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	puts := r.NewCounter("test_ops_total", "Number of operations.", "op", "put")
+	gets := r.NewCounter("test_ops_total", "Number of operations.", "op", "get")
+	gauge := r.NewGauge("test_gauge", "A gauge.")
+	r.NewGaugeFunc("test_gauge_func", "A gauge func.", func() float64 { return 42 })
+	h := r.NewHistogram("test_latency_seconds", "Latency.\nSecond line.", []float64{0.1, 1}, "op", `a"b`)
+
+	puts.Add(3)
+	gets.Inc()
+	gauge.Set(1.5)
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	buf := new(bytes.Buffer)
+	err := r.WritePrometheus(buf)
+	assert.Nil(t, err)
+	expected := `# HELP test_ops_total Number of operations.
+# TYPE test_ops_total counter
+test_ops_total{op="put"} 3
+test_ops_total{op="get"} 1
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 1.5
+# HELP test_gauge_func A gauge func.
+# TYPE test_gauge_func gauge
+test_gauge_func 42
+# HELP test_latency_seconds Latency.\nSecond line.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{op="a\"b",le="0.1"} 2
+test_latency_seconds_bucket{op="a\"b",le="1"} 3
+test_latency_seconds_bucket{op="a\"b",le="+Inf"} 4
+test_latency_seconds_sum{op="a\"b"} 2.65
+test_latency_seconds_count{op="a\"b"} 4
+`
+	assert.Equal(t, expected, buf.String())
+	assert.Equal(t, uint64(4), h.Count())
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "", "op", "put")
+	assert.Panics(t, func() {
+		r.NewCounter("test_total", "", "op", "put")
+	})
+	assert.Panics(t, func() {
+		r.NewGauge("test_total", "", "op", "get")
+	})
+	assert.Panics(t, func() {
+		r.NewCounter("test_labels_total", "", "op")
+	})
+	assert.Panics(t, func() {
+		r.NewHistogram("test_seconds", "", []float64{1, 0.1})
+	})
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.").Inc()
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+	assert.Contains(t, recorder.Body.String(), "test_total 1\n")
+}
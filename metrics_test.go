@@ -0,0 +1,68 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Metrics(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-metrics")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	m := db.metrics
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 50; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		err = db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	assert.Equal(t, uint64(100), m.puts.Value())
+	assert.Equal(t, uint64(100), m.putLatency.Count())
+	assert.Equal(t, uint64(50), m.gets.Value())
+	assert.Equal(t, uint64(50), m.deletes.Value())
+	assert.Equal(t, uint64(1), m.commits.Value())
+	assert.True(t, m.bytesWritten.Value() > 100*1024)
+	assert.True(t, m.bytesRead.Value() > 50*1024)
+	assert.True(t, m.rotations.Value() > 0)
+	assert.True(t, m.syncs.Value() >= m.rotations.Value())
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), m.merges.Value())
+	assert.Equal(t, uint64(1), m.mergeDuration.Count())
+	assert.True(t, m.mergeReclaimed.Value() > 50*1024)
+
+	buf := new(bytes.Buffer)
+	err = db.Metrics().WritePrometheus(buf)
+	assert.Nil(t, err)
+	output := buf.String()
+	assert.Contains(t, output, "bitcask_operations_total{op=\"put\"} 100\n")
+	assert.Contains(t, output, "bitcask_operation_duration_seconds_count{op=\"get\"} 50\n")
+	assert.Contains(t, output, "bitcask_keys 51\n")
+	assert.Contains(t, output, "# TYPE bitcask_merge_duration_seconds histogram\n")
+	assert.Contains(t, output, "bitcask_index_load_seconds ")
+
+	// 获取数据目录大小失败时不会 panic
+	db.options.DirPath = filepath.Join(dir, "not-exist")
+	assert.Equal(t, int64(-1), db.Stat().DiskSize)
+	db.options.DirPath = dir
+}
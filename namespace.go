@@ -0,0 +1,297 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 默认的 namespace，即直接通过 DB 读写的数据
+const defaultNamespaceId uint32 = 0
+
+// Namespace 同一个数据目录中的逻辑数据集
+// 和 DB 共享数据文件、文件锁、merge 以及事务序列号，但拥有独立的内存索引、统计信息和迭代器
+type Namespace struct {
+	db          *DB
+	id          uint32
+	name        string
+	index       index.Indexer // 内存索引
+	reclaimSize int64         // 表示有多少数据是无效的
+
+	fileReclaimSize map[uint32]int64 // 每个数据文件中无效的数据量
+}
+
+// Namespace 获取指定名称的 namespace，不存在的话则创建
+// namespace 的名称和 id 的对应关系会持久化到数据目录中
+func (db *DB) Namespace(name string) (*Namespace, error) {
+	if len(name) == 0 {
+		return nil, ErrNamespaceIsEmpty
+	}
+	if db.options.IndexType == BPlusTree {
+		return nil, ErrNamespaceNotSupported
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if id, ok := db.namespaceIds[name]; ok {
+		return db.namespaces[id], nil
+	}
+	if db.options.ReadOnly {
+		return nil, ErrDatabaseIsReadOnly
+	}
+
+	// 分配新的 namespace id，并先持久化对应关系，之后才能写入数据
+	var id = defaultNamespaceId + 1
+	for nsId := range db.namespaces {
+		if nsId >= id {
+			id = nsId + 1
+		}
+	}
+	nsFile, err := data.OpenNamespaceFile(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer nsFile.Close()
+	size, err := nsFile.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	nsFile.WriteOff = size
+
+	idBuf := make([]byte, binary.MaxVarintLen32)
+	n := binary.PutUvarint(idBuf, uint64(id))
+	record := &data.LogRecord{Key: []byte(name), Value: idBuf[:n]}
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := nsFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	if err := nsFile.Sync(); err != nil {
+		return nil, err
+	}
+
+	return db.addNamespace(id, name), nil
+}
+
+// 添加 namespace
+// 在访问此方法前必须持有互斥锁
+func (db *DB) addNamespace(id uint32, name string) *Namespace {
+	ns := &Namespace{
+		db:    db,
+		id:    id,
+		name:  name,
+		index: index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites),
+
+		fileReclaimSize: make(map[uint32]int64),
+	}
+	db.namespaces[id] = ns
+	db.namespaceIds[name] = id
+	return ns
+}
+
+// 从磁盘中加载 namespace 的名称和 id
+func (db *DB) loadNamespaces() error {
+	fileName := filepath.Join(db.options.DirPath, data.NamespaceFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	if db.options.IndexType == BPlusTree {
+		return ErrNamespaceNotSupported
+	}
+
+	nsFile, err := data.OpenNamespaceFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer nsFile.Close()
+
+	var offset int64 = 0
+	for {
+		record, size, err := nsFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		offset += size
+
+		id, _ := binary.Uvarint(record.Value)
+		db.addNamespace(uint32(id), string(record.Key))
+	}
+	return nil
+}
+
+// 根据 namespace id 获取对应的内存索引，namespace 不存在时返回 nil
+// 在访问此方法前必须持有互斥锁
+func (db *DB) indexOf(nsId uint32) index.Indexer {
+	if nsId == defaultNamespaceId {
+		return db.index
+	}
+	if ns, ok := db.namespaces[nsId]; ok {
+		return ns.index
+	}
+	return nil
+}
+
+// 复制所有 namespace 的内存索引，包含默认的 namespace，供 merge 在不持有锁时查找索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) copyIndexes() map[uint32]index.Indexer {
+	indexes := make(map[uint32]index.Indexer, len(db.namespaces)+1)
+	indexes[defaultNamespaceId] = db.index
+	for nsId, ns := range db.namespaces {
+		indexes[nsId] = ns.index
+	}
+	return indexes
+}
+
+// 记录 namespace 中被覆盖或者删除的数据所占用的空间，同时计入 DB 的统计
+// 在访问此方法前必须持有互斥锁
+func (db *DB) addNamespaceReclaimSize(nsId uint32, pos *data.LogRecordPos) {
+	db.addReclaimSize(pos)
+	if ns, ok := db.namespaces[nsId]; ok {
+		ns.reclaimSize += int64(pos.Size)
+		ns.fileReclaimSize[pos.Fid] += int64(pos.Size)
+	}
+}
+
+// Name namespace 的名称
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Put 写入 Key/Value 数据，key 不能为空
+func (ns *Namespace) Put(key []byte, value []byte) error {
+	return ns.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL 写入 Key/Value 数据，并指定过期时间，ttl 小于等于 0 表示永不过期
+func (ns *Namespace) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if ns.db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
+
+	// 压缩和加密不需要持有互斥锁
+	encoded, err := ns.db.encodeValue(value)
+	if err != nil {
+		return err
+	}
+	return ns.db.commit(ns.db.options.SyncWrites, func() error {
+		return ns.put(key, encoded, data.ExpireAt(ttl))
+	})
+}
+
+// 写入提前压缩和加密过的数据并更新 namespace 的索引
+// 在访问此方法前必须持有 db 的互斥锁
+func (ns *Namespace) put(key []byte, encoded *encodedValue, expire int64) error {
+	logRecord := &data.LogRecord{
+		Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type:      data.LogRecordNormal,
+		Expire:    expire,
+		Namespace: ns.id,
+	}
+	pos, err := ns.db.appendEncodedRecord(logRecord, encoded, ns.db.options.SyncWrites)
+	if err != nil {
+		return err
+	}
+	ns.db.saveTxnSnapshot(ns.id, key)
+	if oldPos := ns.index.Put(key, pos); oldPos != nil {
+		ns.db.addNamespaceReclaimSize(ns.id, oldPos)
+	}
+	return nil
+}
+
+// Get 根据 key 读取数据
+func (ns *Namespace) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+
+	logRecordPos := ns.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return ns.db.getValueByPosition(logRecordPos, DefaultReadOptions)
+}
+
+// Delete 根据 key 删除对应的数据
+func (ns *Namespace) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if ns.db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
+
+	return ns.db.commit(ns.db.options.SyncWrites, func() error {
+		return ns.delete(key)
+	})
+}
+
+// 写入删除记录并更新 namespace 的索引
+// 在访问此方法前必须持有 db 的互斥锁
+func (ns *Namespace) delete(key []byte) error {
+	if pos := ns.index.Get(key); pos == nil {
+		return nil
+	}
+	logRecord := &data.LogRecord{
+		Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type:      data.LogRecordDeleted,
+		Namespace: ns.id,
+	}
+	pos, err := ns.db.appendLogRecord(logRecord, ns.db.options.SyncWrites)
+	if err != nil {
+		return err
+	}
+	ns.db.addNamespaceReclaimSize(ns.id, pos)
+
+	ns.db.saveTxnSnapshot(ns.id, key)
+	oldPos, ok := ns.index.Delete(key)
+	if !ok {
+		return ErrIndexUpdateFailed
+	}
+	if oldPos != nil {
+		ns.db.addNamespaceReclaimSize(ns.id, oldPos)
+	}
+	return nil
+}
+
+// ListKeys 获取 namespace 中所有的 key
+func (ns *Namespace) ListKeys() [][]byte {
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+	return listKeys(ns.index)
+}
+
+// Fold 获取 namespace 中所有的数据，并执行用户指定的操作，函数返回 false 时终止遍历
+func (ns *Namespace) Fold(fn func(key []byte, value []byte) bool) error {
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+	return ns.db.fold(ns.index, fn)
+}
+
+// NewIterator 初始化 namespace 的迭代器
+// 迭代器使用完之后需要关闭，否则 merge 替换下来的数据文件无法删除
+func (ns *Namespace) NewIterator(opts IteratorOptions) *Iterator {
+	return ns.db.newIterator(ns.index, opts)
+}
+
+// Stat 返回 namespace 的统计信息，数据文件相关的信息和 DB 共享
+func (ns *Namespace) Stat() *Stat {
+	stat := ns.db.Stat()
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+	stat.KeyNum = uint(ns.index.Size())
+	stat.ReclaimableSize = ns.reclaimSize
+	return stat
+}
@@ -0,0 +1,226 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Namespace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	_, err = db.Namespace("")
+	assert.Equal(t, ErrNamespaceIsEmpty, err)
+	users, err := db.Namespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, "users", users.Name())
+	orders, err := db.Namespace("orders")
+	assert.Nil(t, err)
+	users2, err := db.Namespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, users, users2)
+
+	// 相同的 key 在不同的 namespace 中互不影响
+	key := utils.GetTestKey(1)
+	err = db.Put(key, []byte("default"))
+	assert.Nil(t, err)
+	err = users.Put(key, []byte("users"))
+	assert.Nil(t, err)
+	_, err = orders.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	val, err = users.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+
+	for i := 10; i < 20; i++ {
+		err := orders.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	err = orders.Delete(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, 9, len(orders.ListKeys()))
+	assert.Equal(t, 1, len(db.ListKeys()))
+	assert.Equal(t, uint(9), orders.Stat().KeyNum)
+	assert.Greater(t, orders.Stat().ReclaimableSize, int64(0))
+	assert.Equal(t, int64(0), users.Stat().ReclaimableSize)
+
+	iter := orders.NewIterator(DefaultIteratorOptions)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		_, err := iter.Value()
+		assert.Nil(t, err)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 9, count)
+
+	// WriteBatch 跨 namespace 原子提交
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(2), []byte("default-2"))
+	assert.Nil(t, err)
+	err = wb.PutIn(users, utils.GetTestKey(2), []byte("users-2"))
+	assert.Nil(t, err)
+	err = wb.DeleteIn(orders, utils.GetTestKey(11))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	val, err = users.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users-2"), val)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default-2"), val)
+	_, err = orders.Get(utils.GetTestKey(11))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后 namespace 和数据都能恢复
+	check := func(db *DB) {
+		users, err := db.Namespace("users")
+		assert.Nil(t, err)
+		orders, err := db.Namespace("orders")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(db.ListKeys()))
+		assert.Equal(t, 2, len(users.ListKeys()))
+		assert.Equal(t, 8, len(orders.ListKeys()))
+		val, err := users.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("users"), val)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// merge 之后从 hint 文件中加载
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// B+ 树索引不支持 namespace
+	opts.IndexType = BPlusTree
+	_, err = Open(opts)
+	assert.Equal(t, ErrNamespaceNotSupported, err)
+}
+
+func TestDB_Namespace_Txn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-txn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	users, err := db.Namespace("users")
+	assert.Nil(t, err)
+	key := utils.GetTestKey(1)
+	err = users.Put(key, []byte("v1"))
+	assert.Nil(t, err)
+
+	// 事务读取到的是快照数据，读取过的 namespace key 被修改之后提交失败
+	txn, err := db.Begin(false)
+	assert.Nil(t, err)
+	val, err := txn.GetIn(users, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	_, err = txn.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = users.Put(key, []byte("v2"))
+	assert.Nil(t, err)
+	val, err = txn.GetIn(users, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	assert.Nil(t, txn.PutIn(users, utils.GetTestKey(2), []byte("v1")))
+	assert.Equal(t, ErrTxnConflict, txn.Commit())
+	_, err = users.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 删除同样会产生冲突
+	txn, err = db.Begin(false)
+	assert.Nil(t, err)
+	_, err = txn.GetIn(users, key)
+	assert.Nil(t, err)
+	err = users.Delete(key)
+	assert.Nil(t, err)
+	_, err = txn.GetIn(users, key)
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put(key, []byte("default")))
+	assert.Equal(t, ErrTxnConflict, txn.Commit())
+
+	// 默认 namespace 中相同的 key 不会产生冲突
+	txn, err = db.Begin(false)
+	assert.Nil(t, err)
+	_, err = txn.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = users.Put(key, []byte("v3"))
+	assert.Nil(t, err)
+	assert.Nil(t, txn.PutIn(users, utils.GetTestKey(2), []byte("v1")))
+	assert.Nil(t, txn.DeleteIn(users, key))
+	assert.Nil(t, txn.Commit())
+	_, err = users.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = users.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+}
+
+func TestDB_Namespace_CreateDuringMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i%500), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+
+	// merge 期间创建 namespace 不会和 merge 并发读写 namespace 的映射
+	createNamespaces := func(start int) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := start; i < start+100; i++ {
+				ns, err := db.Namespace(string(utils.GetTestKey(i)))
+				assert.Nil(t, err)
+				assert.Nil(t, ns.Put([]byte("key"), []byte("value")))
+			}
+		}()
+		return done
+	}
+	done := createNamespaces(0)
+	assert.Nil(t, db.Merge())
+	<-done
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i%500), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	done = createNamespaces(100)
+	assert.Nil(t, db.MergeFiles(MergeOptions{}))
+	<-done
+	assert.Equal(t, 500, len(db.ListKeys()))
+}
@@ -0,0 +1,359 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MergeOperatorInt64Add 整数累加，value 和操作数都是 8 字节大端序的 int64，key 不存在时从 0 开始累加
+	MergeOperatorInt64Add = "int64add"
+
+	// MergeOperatorAppend 追加，操作数依次追加到原来的 value 之后
+	MergeOperatorAppend = "append"
+
+	// 同一个 key 上未合并的操作数超过这个数量时，写入时直接合并，避免读取时需要读取过多的记录
+	maxMergeOperands = 64
+)
+
+// MergeOperator 合并操作，可以通过 RegisterMergeOperator 接入自定义的合并操作
+// MergeWith 只写入操作数，读取时按照写入顺序合并到原来的值上，merge 时会直接写入合并之后的值
+type MergeOperator interface {
+	// Name 合并操作的名称，会和操作数一起写入到数据文件中
+	Name() string
+
+	// Merge 将 operands 按照顺序合并到 existing 上，exists 表示 key 原来是否存在
+	Merge(existing []byte, exists bool, operands [][]byte) ([]byte, error)
+}
+
+var (
+	mergeOperatorsLock = new(sync.RWMutex)
+	mergeOperators     = map[string]MergeOperator{
+		MergeOperatorInt64Add: int64AddOperator{},
+		MergeOperatorAppend:   appendOperator{},
+	}
+)
+
+// RegisterMergeOperator 注册自定义的合并操作，相同名称的合并操作会被覆盖
+// 已经写入数据文件的合并操作需要一直保持注册，否则对应的数据无法读取
+func RegisterMergeOperator(op MergeOperator) {
+	if op.Name() == "" {
+		panic("cannot register merge operator with empty name")
+	}
+	mergeOperatorsLock.Lock()
+	defer mergeOperatorsLock.Unlock()
+	mergeOperators[op.Name()] = op
+}
+
+// GetMergeOperator 根据名称获取合并操作
+func GetMergeOperator(name string) (MergeOperator, bool) {
+	mergeOperatorsLock.RLock()
+	defer mergeOperatorsLock.RUnlock()
+	op, ok := mergeOperators[name]
+	return op, ok
+}
+
+type int64AddOperator struct{}
+
+func (int64AddOperator) Name() string {
+	return MergeOperatorInt64Add
+}
+
+func (int64AddOperator) Merge(existing []byte, exists bool, operands [][]byte) ([]byte, error) {
+	var sum int64
+	if exists {
+		if len(existing) != 8 {
+			return nil, ErrInvalidMergeOperand
+		}
+		sum = int64(binary.BigEndian.Uint64(existing))
+	}
+	for _, operand := range operands {
+		if len(operand) != 8 {
+			return nil, ErrInvalidMergeOperand
+		}
+		sum += int64(binary.BigEndian.Uint64(operand))
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(sum))
+	return value, nil
+}
+
+type appendOperator struct{}
+
+func (appendOperator) Name() string {
+	return MergeOperatorAppend
+}
+
+func (appendOperator) Merge(existing []byte, exists bool, operands [][]byte) ([]byte, error) {
+	size := len(existing)
+	for _, operand := range operands {
+		size += len(operand)
+	}
+	value := make([]byte, 0, size)
+	value = append(value, existing...)
+	for _, operand := range operands {
+		value = append(value, operand...)
+	}
+	return value, nil
+}
+
+// 合并操作数记录在数据文件中的位置
+type operandPos struct {
+	fid    uint32
+	offset int64
+}
+
+// 合并操作数记录的前一个记录，只保存在内存中，启动时按照写入顺序重建
+type operandNode struct {
+	key   []byte
+	prev  *data.LogRecordPos // 前一个记录的位置，为空表示 key 原来不存在
+	depth int                // 包括自身在内还没有合并的操作数数量
+}
+
+func operandPosOf(pos *data.LogRecordPos) operandPos {
+	return operandPos{fid: pos.Fid, offset: pos.Offset}
+}
+
+// MergeWith 写入 key 的合并操作数，读取时使用名称为 operator 的合并操作合并到原来的值上
+// key 原来的过期时间保持不变
+func (db *DB) MergeWith(key []byte, operator string, operand []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
+	op, ok := GetMergeOperator(operator)
+	if !ok {
+		return ErrMergeOperatorNotFound
+	}
+
+	return db.commit(db.options.SyncWrites, func() error {
+		return db.mergeWith(key, op, operand, db.options.SyncWrites)
+	})
+}
+
+// 写入合并操作数并更新内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) mergeWith(key []byte, op MergeOperator, operand []byte, sync bool) error {
+	prev := db.index.Get(key)
+	if prev != nil && prev.IsExpired(time.Now().UnixNano()) {
+		prev = nil
+	}
+	depth := 1
+	if prev != nil {
+		if node, ok := db.operands[operandPosOf(prev)]; ok {
+			depth = node.depth + 1
+		}
+	}
+
+	// merge 期间写入的操作数无法和被重写的记录关联，B+ 树索引在启动时无法重建关联
+	// 分离到 blob 文件中的值也不能作为操作数的基础值，这些情况下直接写入合并之后的值
+	if db.isMerging || db.options.IndexType == BPlusTree ||
+		(prev != nil && prev.BlobSize > 0) || depth > maxMergeOperands {
+		var expire int64
+		existing, err := db.get(key, DefaultReadOptions)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if prev != nil {
+			expire = prev.Expire
+		}
+		value, err := op.Merge(existing, err == nil, [][]byte{operand})
+		if err != nil {
+			return err
+		}
+		return db.put(key, value, nil, expire, sync)
+	}
+
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value: encodeMergeOperand(op.Name(), operand),
+		Type:  data.LogRecordMergeOperand,
+	}
+	if prev != nil {
+		logRecord.Expire = prev.Expire
+	}
+	pos, err := db.appendLogRecord(logRecord, sync)
+	if err != nil {
+		return err
+	}
+
+	db.saveTxnSnapshot(defaultNamespaceId, key)
+	db.putMergeOperand(key, pos, prev, depth)
+	// 只有存在订阅者时才需要读取合并之后的值
+	if atomic.LoadInt32(&db.watch.watcherNum) > 0 {
+		value, err := db.getValueByPosition(pos, DefaultReadOptions)
+		if err != nil {
+			return err
+		}
+		db.addWatchEvent(WatchEventPut, key, value, nonTransactionSeqNo)
+	}
+	return nil
+}
+
+// 将合并操作数的位置写入索引，并记录它的前一个记录
+// 在访问此方法前必须持有互斥锁
+func (db *DB) putMergeOperand(key []byte, pos, prev *data.LogRecordPos, depth int) {
+	// 前一个记录仍然是有效的基础值，不计入无效数据
+	if oldPos := db.index.Put(key, pos); oldPos != nil && prev == nil {
+		db.addReclaimSize(oldPos)
+	}
+	db.operands[operandPosOf(pos)] = &operandNode{key: key, prev: prev, depth: depth}
+}
+
+// 读取合并操作数记录，从前一个记录开始向前找到原来的值，再按照写入顺序依次合并
+// 在访问此方法前必须持有读锁或者互斥锁
+func (db *DB) readMergedValue(pos *data.LogRecordPos, logRecord *data.LogRecord, epoch uint64, opts ReadOptions) ([]byte, error) {
+	records := []*data.LogRecord{logRecord}
+	var existing []byte
+	var exists bool
+	for {
+		node, ok := db.operandAt(pos, epoch)
+		// 操作数所在的数据文件已经被 merge 替换
+		if !ok {
+			return nil, ErrDataFileNotFound
+		}
+		if node.prev == nil {
+			break
+		}
+		pos = node.prev
+		prevRecord, err := db.readLogRecordAt(pos, epoch, opts)
+		if err != nil {
+			return nil, err
+		}
+		if prevRecord.Type == data.LogRecordMergeOperand {
+			records = append(records, prevRecord)
+			continue
+		}
+		if prevRecord.Type == data.LogRecordNormal {
+			existing, exists = prevRecord.Value, true
+			if prevRecord.BlobRef {
+				if existing, err = db.readBlobValue(data.DecodeBlobPos(prevRecord.Value), opts); err != nil {
+					return nil, err
+				}
+			}
+		}
+		break
+	}
+
+	// 按照写入的顺序合并
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return applyMergeOperands(existing, exists, records)
+}
+
+// 获取持有指定版本的读取者看到的合并操作数记录，优先使用 merge 替换下来的版本
+// 在访问此方法前必须持有读锁或者互斥锁
+func (db *DB) operandAt(pos *data.LogRecordPos, epoch uint64) (*operandNode, bool) {
+	opPos := operandPosOf(pos)
+	if node := db.epochs.getOperand(opPos, epoch); node != nil {
+		return node, true
+	}
+	node, ok := db.operands[opPos]
+	return node, ok
+}
+
+// 将合并操作数记录依次合并到 existing 上，连续的使用相同合并操作的操作数一起合并
+func applyMergeOperands(existing []byte, exists bool, records []*data.LogRecord) ([]byte, error) {
+	var curName string
+	var operands [][]byte
+	flush := func() error {
+		if len(operands) == 0 {
+			return nil
+		}
+		op, ok := GetMergeOperator(curName)
+		if !ok {
+			return ErrMergeOperatorNotFound
+		}
+		value, err := op.Merge(existing, exists, operands)
+		if err != nil {
+			return err
+		}
+		existing, exists, operands = value, true, nil
+		return nil
+	}
+	for _, record := range records {
+		name, operand, err := decodeMergeOperand(record.Value)
+		if err != nil {
+			return nil, err
+		}
+		if name != curName {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			curName = name
+		}
+		operands = append(operands, operand)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// 将索引中所有未合并的操作数合并之后重写，merge 之前调用，保证被重写的数据文件中没有未合并的操作数
+// 在访问此方法前必须持有互斥锁
+func (db *DB) foldMergeOperands() error {
+	now := time.Now().UnixNano()
+	for opPos, node := range db.operands {
+		pos := db.index.Get(node.key)
+		if pos == nil || operandPosOf(pos) != opPos || pos.IsExpired(now) {
+			continue
+		}
+		logRecord, err := db.readLogRecordByPosition(pos, DefaultReadOptions)
+		if err != nil {
+			return err
+		}
+		value, err := db.readMergedValue(pos, logRecord, latestEpoch, DefaultReadOptions)
+		if err != nil {
+			return err
+		}
+		// 合并之后的值没有变化，保留最后一个操作数的写入时间
+		newPos, err := db.appendRewrittenRecord(&data.LogRecord{
+			Key:       logRecordKeyWithSeq(node.key, nonTransactionSeqNo),
+			Value:     value,
+			Type:      data.LogRecordNormal,
+			Expire:    pos.Expire,
+			Timestamp: logRecord.Timestamp,
+		}, false)
+		if err != nil {
+			return err
+		}
+		db.index.Put(node.key, newPos)
+		db.addReclaimSize(pos)
+	}
+	return nil
+}
+
+// 删除指定数据文件中的合并操作数记录，merge 之前创建的读取者依然可以通过版本读取
+// 在访问此方法前必须持有互斥锁
+func (db *DB) dropMergeOperands(fileId uint32) {
+	for opPos, node := range db.operands {
+		if opPos.fid == fileId {
+			db.epochs.retireOperand(opPos, node)
+			delete(db.operands, opPos)
+		}
+	}
+}
+
+// 合并操作数的编码，操作名称的长度 | 操作名称 | 操作数
+func encodeMergeOperand(name string, operand []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+len(name)+len(operand))
+	n := binary.PutUvarint(buf, uint64(len(name)))
+	n += copy(buf[n:], name)
+	n += copy(buf[n:], operand)
+	return buf[:n]
+}
+
+func decodeMergeOperand(buf []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < size {
+		return "", nil, ErrInvalidMergeOperand
+	}
+	return string(buf[n : n+int(size)]), buf[n+int(size):], nil
+}
@@ -0,0 +1,347 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func int64Bytes(v int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(v))
+	return buf
+}
+
+func TestDB_Update(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-update")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Update(nil, nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// 并发累加
+	key := utils.GetTestKey(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				err := db.Update(key, func(old []byte, exists bool) ([]byte, bool, error) {
+					var v int64
+					if exists {
+						v = int64(binary.BigEndian.Uint64(old))
+					}
+					return int64Bytes(v + 1), false, nil
+				})
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(1000), val)
+
+	// 返回错误时不写入数据
+	errAbort := errors.New("abort")
+	err = db.Update(key, func(old []byte, exists bool) ([]byte, bool, error) {
+		return nil, false, errAbort
+	})
+	assert.Equal(t, errAbort, err)
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(1000), val)
+
+	// 删除
+	err = db.Update(key, func(old []byte, exists bool) ([]byte, bool, error) {
+		assert.True(t, exists)
+		return nil, true, nil
+	})
+	assert.Nil(t, err)
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db.Update(key, func(old []byte, exists bool) ([]byte, bool, error) {
+		assert.False(t, exists)
+		return nil, true, nil
+	})
+	assert.Nil(t, err)
+
+	// 保留原来的过期时间
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(10), time.Millisecond*50)
+	assert.Nil(t, err)
+	err = db.Update(utils.GetTestKey(2), func(old []byte, exists bool) ([]byte, bool, error) {
+		return append(old, 'a'), false, nil
+	})
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 60)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_MergeWith(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-with")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	err = db.MergeWith(utils.GetTestKey(1), "unknown", nil)
+	assert.Equal(t, ErrMergeOperatorNotFound, err)
+
+	// key 不存在时从 0 开始累加
+	counter := utils.GetTestKey(1)
+	for i := 1; i <= 10; i++ {
+		err := db.MergeWith(counter, MergeOperatorInt64Add, int64Bytes(int64(i)))
+		assert.Nil(t, err)
+	}
+	// 在已有的值上追加
+	list := utils.GetTestKey(2)
+	err = db.Put(list, []byte("a"))
+	assert.Nil(t, err)
+	for _, s := range []string{"b", "c", "d"} {
+		err := db.MergeWith(list, MergeOperatorAppend, []byte(s))
+		assert.Nil(t, err)
+	}
+	// 操作数写入之后被覆盖
+	overwritten := utils.GetTestKey(3)
+	err = db.MergeWith(overwritten, MergeOperatorAppend, []byte("x"))
+	assert.Nil(t, err)
+	err = db.Put(overwritten, []byte("y"))
+	assert.Nil(t, err)
+	// 超过上限之后直接写入合并之后的值
+	long := utils.GetTestKey(4)
+	for i := 0; i < maxMergeOperands*2; i++ {
+		err := db.MergeWith(long, MergeOperatorInt64Add, int64Bytes(1))
+		assert.Nil(t, err)
+	}
+	assert.True(t, db.operands[operandPosOf(db.index.Get(long))].depth <= maxMergeOperands)
+
+	check := func(db *DB) {
+		val, err := db.Get(counter)
+		assert.Nil(t, err)
+		assert.Equal(t, int64Bytes(55), val)
+		val, err = db.Get(list)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("abcd"), val)
+		val, err = db.Get(overwritten)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("y"), val)
+		val, err = db.Get(long)
+		assert.Nil(t, err)
+		assert.Equal(t, int64Bytes(maxMergeOperands*2), val)
+	}
+	check(db)
+
+	// 操作数格式错误时读取失败
+	err = db.MergeWith(overwritten, MergeOperatorInt64Add, int64Bytes(1))
+	assert.Nil(t, err)
+	_, err = db.Get(overwritten)
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+	err = db.Put(overwritten, []byte("y"))
+	assert.Nil(t, err)
+
+	// 重启之后重建操作数之间的关联
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	err = db.MergeWith(list, MergeOperatorAppend, []byte("e"))
+	assert.Nil(t, err)
+	val, err := db.Get(list)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abcde"), val)
+
+	// merge 时写入合并之后的值
+	err = db.Merge()
+	assert.Nil(t, err)
+	pos := db.index.Get(list)
+	logRecord, err := db.readLogRecordByPosition(pos, DefaultReadOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, data.LogRecordNormal, logRecord.Type)
+	assert.Equal(t, []byte("abcde"), logRecord.Value)
+	assert.Equal(t, 0, len(db.operands))
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get(list)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abcde"), val)
+	val, err = db.Get(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(55), val)
+}
+
+func TestDB_MergeWith_MergeFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-with-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 原来的值在 0 号文件中
+	key := []byte("counter")
+	err = db.Put(key, int64Bytes(100))
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	err = db.MergeWith(key, MergeOperatorInt64Add, int64Bytes(5))
+	assert.Nil(t, err)
+	// 0 号文件中的大部分数据失效
+	for i := 0; i < 900; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	err = db.MergeFiles(DefaultMergeOptions)
+	assert.Nil(t, err)
+	err = db.MergeWith(key, MergeOperatorInt64Add, int64Bytes(5))
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(110), val)
+}
+
+func TestDB_MergeWith_IteratorAcrossMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-with-iterator")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	key := []byte("counter")
+	err = db.Put(key, int64Bytes(100))
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	err = db.MergeWith(key, MergeOperatorInt64Add, int64Bytes(5))
+	assert.Nil(t, err)
+	for i := 0; i < 900; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// merge 之前创建的迭代器和事务依然可以读取合并操作数
+	iter := db.NewIterator(IteratorOptions{Prefix: key})
+	defer iter.Close()
+	txn, err := db.Begin(true)
+	assert.Nil(t, err)
+	defer txn.Rollback()
+
+	err = db.MergeFiles(DefaultMergeOptions)
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	val, err := iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(105), val)
+	val, err = txn.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(105), val)
+
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(105), val)
+}
+
+func TestDB_MergeWith_GetAt(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-with-get-at")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	key := utils.GetTestKey(1)
+	err = db.MergeWith(key, MergeOperatorAppend, []byte("a"))
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	t1 := time.Now()
+	time.Sleep(time.Millisecond * 5)
+	err = db.MergeWith(key, MergeOperatorAppend, []byte("b"))
+	assert.Nil(t, err)
+
+	val, err := db.GetAt(key, t1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	val, err = db.GetAt(key, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ab"), val)
+}
+
+type maxOperator struct{}
+
+func (maxOperator) Name() string {
+	return "test-max"
+}
+
+func (maxOperator) Merge(existing []byte, exists bool, operands [][]byte) ([]byte, error) {
+	result := existing
+	for _, operand := range operands {
+		if !exists || string(operand) > string(result) {
+			result, exists = operand, true
+		}
+	}
+	return result, nil
+}
+
+func TestRegisterMergeOperator(t *testing.T) {
+	RegisterMergeOperator(maxOperator{})
+	op, ok := GetMergeOperator("test-max")
+	assert.True(t, ok)
+	assert.Equal(t, "test-max", op.Name())
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-register-merge-operator")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for _, s := range []string{"b", "d", "a", "c"} {
+		err := db.MergeWith([]byte("max"), "test-max", []byte(s))
+		assert.Nil(t, err)
+	}
+	val, err := db.Get([]byte("max"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), val)
+}
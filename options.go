@@ -1,5 +1,10 @@
 package bitcask_go
 
+import (
+	"bitcask-go/data"
+	"time"
+)
+
 type Options struct {
 	// 数据库数据目录
 	DirPath string
@@ -21,6 +26,89 @@ type Options struct {
 
 	//	数据文件合并的阈值
 	DataFileMergeRatio float32
+
+	// 是否以只读模式打开，只读模式下获取的是共享文件锁，多个只读实例可以同时打开同一个数据目录
+	// 只读实例可以和正在运行的读写实例同时打开，加载的是打开时的数据，之后的写入不可见
+	// 使用 B+ 树索引时索引文件被读写实例独占，只读实例不能和读写实例同时打开
+	ReadOnly bool
+
+	// 每个订阅者的事件缓冲区大小
+	WatchBufferSize int
+
+	// 订阅者消费过慢、缓冲区已满时的处理策略
+	WatchPolicy WatchPolicy
+
+	// merge 时保留历史版本的时间窗口，写入时间在窗口内的历史版本不会被清理，可以通过 GetAt 读取
+	// 默认为 0，表示 merge 时只保留最新的数据
+	MergeRetention time.Duration
+
+	// value 的压缩算法，默认不压缩，自定义的压缩算法需要先通过 data.RegisterCodec 注册
+	// 压缩算法会记录在每条数据中，修改配置之后已有的数据依然可以正常读取
+	Compression CompressionType
+
+	// 数据文件和 hint 文件的加密，默认为 nil，表示不加密，可以使用 data.NewAESGCMEncryptor 创建
+	// 每条数据中会记录加密使用的密钥 id，轮换密钥之后旧的密钥需要保留用于解密，merge 时会使用当前的密钥重新加密
+	Encryption Encryptor
+
+	// 是否同时加密 key，默认只加密 value
+	// B+ 树索引文件需要按照明文 key 的顺序查找和遍历，加密之后无法使用，因此 B+ 树索引不支持加密 key，打开时返回 ErrEncryptKeyNotSupported
+	EncryptKeys bool
+
+	// value 分离的阈值，大于阈值的 value 会单独存储到 blob 文件中，数据文件中只保存 blob 位置
+	// merge 时不需要重写这些 value，默认为 0，表示不分离
+	ValueThreshold int
+
+	// blob 文件的大小
+	BlobFileSize int64
+
+	// blob 文件回收的阈值，无效数据占比达到阈值的 blob 文件才会被 CompactBlobs 回收
+	BlobGCRatio float32
+
+	// 后台自动 merge 的检查间隔，默认为 0，表示不启动后台自动 merge
+	// 每次检查时无效数据占比达到 DataFileMergeRatio 才会执行 merge
+	AutoMergeInterval time.Duration
+
+	// 允许后台自动 merge 的时间窗口，默认为空，表示任何时间都可以
+	AutoMergeWindows []MergeWindow
+
+	// 后台自动 merge 的最小回收量，无效数据量小于该值时不执行 merge
+	AutoMergeMinReclaimSize int64
+
+	// 启动时发现数据文件损坏的恢复策略，丢弃的数据可以通过 DB.RecoveryReport 查看，默认为 RecoveryFail
+	// B+ 树索引启动时不会加载数据文件，拥有完整 hint 文件的数据文件启动时不会被扫描，因此不会检查这些数据文件是否损坏
+	RecoveryPolicy RecoveryPolicy
+
+	// merge 和备份每秒读写的字节数，避免占满磁盘带宽影响正常的读写，默认为 0，表示不限速
+	// 运行期间可以通过 DB.SetBackgroundRateLimit 调整
+	BackgroundIOBytesPerSec int64
+
+	// 启动时并发解码数据文件的协程数量，默认为 0，表示使用 CPU 的数量
+	// 同时解码的数据文件越多，加载索引时占用的内存越多
+	IndexLoadConcurrency int
+
+	// 启动时加载索引的进度回调，每加载完一个数据文件调用一次，在 Open 的协程中执行
+	OnIndexLoadProgress func(progress IndexLoadProgress)
+
+	// 存储引擎内部事件的监听者，默认为空，回调在独立的协程中执行，不会阻塞写入
+	EventListener EventListener
+
+	// 等待监听者处理的事件数量上限，超过之后新的事件会被丢弃，丢弃的数量可以通过 Stat 查看
+	EventBufferSize int
+}
+
+// IndexLoadProgress 启动时加载索引的进度，数据文件按照 id 从小到大的顺序加载
+type IndexLoadProgress struct {
+	FilesLoaded int   // 已经加载的数据文件数量
+	TotalFiles  int   // 需要加载的数据文件数量
+	BytesLoaded int64 // 已经加载的数据文件大小
+	TotalBytes  int64 // 需要加载的数据文件大小
+}
+
+// MergeWindow 允许后台自动 merge 的时间窗口，使用相对于当天零点（本地时间）的偏移表示
+// Start 大于 End 时表示跨越零点的时间窗口，例如 {22 * time.Hour, 6 * time.Hour}
+type MergeWindow struct {
+	Start time.Duration
+	End   time.Duration
 }
 
 // IteratorOptions 索引迭代器配置项
@@ -40,6 +128,30 @@ type WriteBatchOptions struct {
 	SyncWrites bool
 }
 
+// WriteOptions 单次写入的配置项
+type WriteOptions struct {
+	// 写入之后是否立即持久化，不受 Options.SyncWrites 的影响
+	Sync bool
+
+	// 数据的过期时间，小于等于 0 表示永不过期，删除数据时不生效
+	TTL time.Duration
+}
+
+// ReadOptions 单次读取的配置项
+type ReadOptions struct {
+	// 是否校验数据的 CRC，不校验时读取更快，但是无法发现损坏的数据
+	VerifyChecksum bool
+}
+
+// MergeOptions 选择性 merge 配置项，只重写无效数据较多的数据文件
+type MergeOptions struct {
+	// 数据文件中无效数据的占比达到这个阈值才会参与 merge
+	FileGarbageRatio float32
+
+	// 最多选择无效数据量最大的 TopN 个数据文件，0 表示不限制
+	TopN int
+}
+
 type IndexerType = int8
 
 const (
@@ -53,6 +165,44 @@ const (
 	BPlusTree
 )
 
+type CompressionType = data.CodecType
+
+const (
+	// NoCompression 不压缩
+	NoCompression CompressionType = data.CodecNone
+
+	// FlateCompression DEFLATE 压缩
+	FlateCompression CompressionType = data.CodecFlate
+
+	// GzipCompression gzip 压缩
+	GzipCompression CompressionType = data.CodecGzip
+)
+
+type Encryptor = data.Encryptor
+
+type RecoveryPolicy = int8
+
+const (
+	// RecoveryFail 数据文件损坏时打开失败
+	RecoveryFail RecoveryPolicy = iota
+
+	// RecoveryTruncateTail 截断活跃文件末尾损坏的数据，通常是写入过程中进程崩溃导致的，旧的数据文件损坏时打开失败
+	RecoveryTruncateTail
+
+	// RecoverySkipCorrupt 截断活跃文件末尾损坏的数据，并跳过数据文件中间损坏的数据
+	RecoverySkipCorrupt
+)
+
+type WatchPolicy = int8
+
+const (
+	// WatchDropOnFull 缓冲区满时丢弃事件，并在下一次投递时通过 WatchBatch.Dropped 通知订阅者
+	WatchDropOnFull WatchPolicy = iota
+
+	// WatchBlockOnFull 缓冲区满时阻塞，直到订阅者消费或者取消订阅
+	WatchBlockOnFull
+)
+
 var DefaultOptions = Options{
 	DirPath:            ".\\C:\\Users\\1\\AppData\\Local\\Temp\\", //E:\KV_Projects\temp  os.TempDir()
 	DataFileSize:       256 * 1024 * 1024,                         // 256MB
@@ -61,6 +211,14 @@ var DefaultOptions = Options{
 	IndexType:          BTree,
 	MMapAtStartup:      true,
 	DataFileMergeRatio: 0.5,
+	WatchBufferSize:    1024,
+	WatchPolicy:        WatchDropOnFull,
+	EventBufferSize:    1024,
+	Compression:        NoCompression,
+	ValueThreshold:     0,
+	BlobFileSize:       256 * 1024 * 1024, // 256MB
+	BlobGCRatio:        0.5,
+	RecoveryPolicy:     RecoveryFail,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -72,3 +230,12 @@ var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrites:  true,
 }
+
+var DefaultReadOptions = ReadOptions{
+	VerifyChecksum: true,
+}
+
+var DefaultMergeOptions = MergeOptions{
+	FileGarbageRatio: 0.5,
+	TopN:             0,
+}
@@ -0,0 +1,113 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bytes"
+)
+
+// DeletePrefix 删除所有以 prefix 为前缀的 key，prefix 不能为空
+// 只会写入一条范围删除的记录，而不是为每一个 key 写入一条删除记录
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.DeleteRange(prefix, prefixEnd(prefix))
+}
+
+// DeleteRange 删除 [start, end) 范围内的所有 key，end 为空表示没有上界
+// 只会写入一条范围删除的记录，而不是为每一个 key 写入一条删除记录
+func (db *DB) DeleteRange(start, end []byte) error {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidKeyRange
+	}
+	if db.options.ReadOnly {
+		return ErrDatabaseIsReadOnly
+	}
+
+	return db.commit(db.options.SyncWrites, func() error {
+		return db.deleteRange(start, end, db.options.SyncWrites)
+	})
+}
+
+// 写入范围删除的记录并更新内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) deleteRange(start, end []byte, sync bool) error {
+	keys := indexRangeKeys(db.index, start, end)
+	// 范围内没有 key 的话直接返回
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// 范围的起点作为 key，终点作为 value
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(start, nonTransactionSeqNo),
+		Value: end,
+		Type:  data.LogRecordRangeDeleted,
+	}
+	pos, err := db.appendLogRecord(logRecord, sync)
+	if err != nil {
+		return err
+	}
+	db.addReclaimSize(pos)
+
+	for _, key := range keys {
+		db.saveTxnSnapshot(defaultNamespaceId, key)
+		oldPos, ok := db.index.Delete(key)
+		if !ok {
+			return ErrIndexUpdateFailed
+		}
+		if oldPos != nil {
+			db.addReclaimSize(oldPos)
+		}
+		db.addWatchEvent(WatchEventDelete, key, nil, nonTransactionSeqNo)
+	}
+	return nil
+}
+
+// 加载索引时处理范围删除的记录，从索引中删除范围内的所有 key
+// 在访问此方法前必须持有互斥锁
+func (db *DB) applyRangeDeleted(nsId uint32, start, end []byte) error {
+	idx := db.indexOf(nsId)
+	if idx == nil {
+		return ErrDataDirectoryCorrupted
+	}
+	for _, key := range indexRangeKeys(idx, start, end) {
+		if oldPos, _ := idx.Delete(key); oldPos != nil {
+			db.addNamespaceReclaimSize(nsId, oldPos)
+		}
+	}
+	return nil
+}
+
+// 取出索引中 [start, end) 范围内的所有 key
+func indexRangeKeys(idx index.Indexer, start, end []byte) [][]byte {
+	iterator := idx.Iterator(false)
+	defer iterator.Close()
+	var keys [][]byte
+	for iterator.Seek(start); iterator.Valid(); iterator.Next() {
+		if !keyInRange(iterator.Key(), start, end) {
+			break
+		}
+		// B+ 树索引返回的 key 在迭代器关闭之后失效，需要拷贝
+		keys = append(keys, append([]byte(nil), iterator.Key()...))
+	}
+	return keys
+}
+
+// key 是否在 [start, end) 范围内，end 为空表示没有上界
+func keyInRange(key, start, end []byte) bool {
+	return bytes.Compare(key, start) >= 0 && (len(end) == 0 || bytes.Compare(key, end) < 0)
+}
+
+// 所有以 prefix 为前缀的 key 都小于返回值，prefix 全部是 0xff 时返回 nil，表示没有上界
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	err = db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(10))
+	assert.Equal(t, ErrInvalidKeyRange, err)
+	err = db.DeleteRange(utils.GetTestKey(20), utils.GetTestKey(10))
+	assert.Equal(t, ErrInvalidKeyRange, err)
+	err = db.DeletePrefix(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	for i := 0; i < 300; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+
+	// 范围内没有 key 的时候不会写入记录
+	writeOff := db.activeFile.WriteOff
+	err = db.DeleteRange([]byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, writeOff, db.activeFile.WriteOff)
+
+	// 删除 [10, 20)
+	err = db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(20))
+	assert.Nil(t, err)
+	// 删除 100 ~ 199
+	err = db.DeletePrefix([]byte("bitcask-go-key-0000001"))
+	assert.Nil(t, err)
+
+	check := func(db *DB) {
+		for i := 0; i < 300; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			if (i >= 10 && i < 20) || (i >= 100 && i < 200) {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		}
+		assert.Equal(t, uint(190), db.Stat().KeyNum)
+	}
+	check(db)
+
+	// 范围删除之后重新写入的 key 不受影响
+	val := utils.RandomValue(24)
+	err = db.Put(utils.GetTestKey(150), val)
+	assert.Nil(t, err)
+
+	// 重启之后按照写入顺序重放范围删除的记录
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	v, err := db.Get(utils.GetTestKey(150))
+	assert.Nil(t, err)
+	assert.Equal(t, val, v)
+	err = db.Delete(utils.GetTestKey(150))
+	assert.Nil(t, err)
+	check(db)
+
+	// merge 之后被覆盖的数据会被清理
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// 没有上界
+	err = db.DeleteRange(utils.GetTestKey(250), nil)
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(299))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(249))
+	assert.Nil(t, err)
+}
+
+func TestDB_DeleteRange_MergeFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range-merge-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 3000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	// 范围删除的记录写入 2 号文件，覆盖的 key 在 1 号文件中
+	err = db.DeleteRange(utils.GetTestKey(1000), utils.GetTestKey(1100))
+	assert.Nil(t, err)
+	// 重写 2 号文件中的数据，只重写 2 号文件
+	for i := 2000; i < 3000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	err = db.MergeFiles(DefaultMergeOptions)
+	assert.Nil(t, err)
+
+	// 从 hint 文件中加载范围删除的记录
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 3000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		if i >= 1000 && i < 1100 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestDB_DeleteRange_GetAt(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range-get-at")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	val := utils.RandomValue(10)
+	err = db.Put(utils.GetTestKey(1), val)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	t1 := time.Now()
+	time.Sleep(time.Millisecond * 5)
+
+	err = db.DeletePrefix([]byte("bitcask-go-key"))
+	assert.Nil(t, err)
+
+	v, err := db.GetAt(utils.GetTestKey(1), t1)
+	assert.Nil(t, err)
+	assert.Equal(t, val, v)
+	_, err = db.GetAt(utils.GetTestKey(1), time.Now())
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("ab"), prefixEnd([]byte("aa")))
+	assert.Equal(t, []byte("b"), prefixEnd([]byte{'a', 0xff}))
+	assert.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+}
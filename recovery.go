@@ -0,0 +1,85 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+)
+
+// RecoveryReport 启动时按照恢复策略丢弃的数据
+type RecoveryReport struct {
+	Discarded      []DiscardedRange // 被丢弃的数据，按照加载的顺序排列
+	DiscardedBytes int64            // 被丢弃的数据总量，字节为单位
+}
+
+// DiscardedRange 数据文件中被丢弃的一段数据
+type DiscardedRange struct {
+	FileId    uint32
+	Offset    int64
+	Size      int64
+	Truncated bool  // 是否已经从活跃文件中截断，只读模式下不会截断
+	Err       error // 发现数据损坏时的错误
+}
+
+// RecoveryReport 返回启动时按照 Options.RecoveryPolicy 丢弃的数据
+func (db *DB) RecoveryReport() RecoveryReport {
+	report := db.recovery
+	report.Discarded = append([]DiscardedRange(nil), db.recovery.Discarded...)
+	return report
+}
+
+// 是否是数据损坏导致的读取错误
+func isCorruptError(err error) bool {
+	return err == data.ErrInvalidCRC || err == data.ErrIncompleteLogRecord
+}
+
+// 加载索引时发现数据损坏，按照恢复策略处理，丢弃的数据记录到 report 中
+// 返回下一次读取的位置，返回 io.EOF 表示当前文件后面已经没有有效的数据
+func (db *DB) recoverDataFile(report *RecoveryReport, dataFile *data.DataFile, isActive bool, offset int64, err error) (int64, error) {
+	// 只读实例打开时读写实例可能正在追加写入活跃文件，没有写完的数据不属于打开时的数据
+	if isActive && db.options.ReadOnly && err == data.ErrIncompleteLogRecord {
+		return offset, io.EOF
+	}
+	policy := db.options.RecoveryPolicy
+	if !isCorruptError(err) || policy == RecoveryFail || (!isActive && policy == RecoveryTruncateTail) {
+		return 0, err
+	}
+	fileSize, sizeErr := dataFile.IoManager.Size()
+	if sizeErr != nil {
+		return 0, sizeErr
+	}
+
+	discarded := DiscardedRange{FileId: dataFile.FileId, Offset: offset, Size: fileSize - offset, Err: err}
+	next, skipErr := db.skipCorruptRecord(dataFile, offset, err)
+	if skipErr == nil {
+		discarded.Size = next - offset
+		report.add(discarded)
+		return next, nil
+	}
+	if skipErr != io.EOF && skipErr != err {
+		return 0, skipErr
+	}
+
+	// 后面没有有效的数据，截断活跃文件，后续的写入从最后一条有效的数据之后开始
+	if isActive && !db.options.ReadOnly {
+		if err := dataFile.Truncate(offset); err != nil {
+			return 0, err
+		}
+		discarded.Truncated = true
+	}
+	report.add(discarded)
+	return offset, io.EOF
+}
+
+// 跳过损坏的数据，返回下一条有效数据的位置，后面没有有效的数据时返回 io.EOF
+// 只有 RecoverySkipCorrupt 策略下才会跳过，否则原样返回错误
+func (db *DB) skipCorruptRecord(dataFile *data.DataFile, offset int64, err error) (int64, error) {
+	if !isCorruptError(err) || db.options.RecoveryPolicy != RecoverySkipCorrupt {
+		return 0, err
+	}
+	return dataFile.NextLogRecordOffset(offset)
+}
+
+func (r *RecoveryReport) add(discarded DiscardedRange) {
+	r.Discarded = append(r.Discarded, discarded)
+	r.DiscardedBytes += discarded.Size
+}
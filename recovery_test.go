@@ -0,0 +1,174 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Recovery_TruncateTail(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-tail")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 模拟写入过程中崩溃，活跃文件末尾只写入了半条数据
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte("torn"), Value: utils.RandomValue(128)})
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	fileSize, _ := file.Seek(0, 2)
+	_, err = file.Write(encRecord[:len(encRecord)/2])
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	// 默认的恢复策略是 RecoveryFail，打开失败
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrIncompleteLogRecord, err)
+
+	// 只读实例把活跃文件末尾没有写完的数据当作读写实例正在追加的数据，加载时忽略
+	opts.ReadOnly = true
+	ro, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ro.RecoveryReport().Discarded))
+	assert.Equal(t, 100, len(ro.ListKeys()))
+	assert.Nil(t, ro.Close())
+	opts.ReadOnly = false
+
+	opts.RecoveryPolicy = RecoveryTruncateTail
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	report := db.RecoveryReport()
+	assert.Equal(t, 1, len(report.Discarded))
+	assert.Equal(t, fileSize, report.Discarded[0].Offset)
+	assert.True(t, report.Discarded[0].Truncated)
+	assert.Equal(t, int64(len(encRecord)/2), report.DiscardedBytes)
+	assert.Equal(t, 100, len(db.ListKeys()))
+
+	// 截断之后的写入在重启之后依然可以读取
+	err = db.Put([]byte("after"), []byte("recovery"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db.RecoveryReport().Discarded))
+	val, err := db.Get([]byte("after"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("recovery"), val)
+}
+
+func TestDB_Recovery_SkipCorrupt(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-skip")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 破坏旧数据文件中间的一条数据，没有 hint 文件的数据文件才会在启动时扫描
+	assert.Nil(t, os.Remove(data.GetHintFileName(dir, 0)))
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted"), 1024)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	opts.RecoveryPolicy = RecoveryTruncateTail
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+
+	opts.RecoveryPolicy = RecoverySkipCorrupt
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	report := db.RecoveryReport()
+	assert.Equal(t, 1, len(report.Discarded))
+	assert.Equal(t, uint32(0), report.Discarded[0].FileId)
+	assert.False(t, report.Discarded[0].Truncated)
+	// 只丢失被破坏的数据，后面的数据都可以正常读取
+	keys := len(db.ListKeys())
+	assert.True(t, keys < 1000 && keys >= 998)
+	val, err := db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 跳过损坏数据之后依然可以 merge
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, keys, len(db.ListKeys()))
+}
+
+func TestDB_Recovery_GarbageTail(t *testing.T) {
+	garbage := bytes.Repeat([]byte{0xff}, 64)
+	policies := []RecoveryPolicy{RecoveryFail, RecoveryTruncateTail, RecoverySkipCorrupt}
+	for _, policy := range policies {
+		for _, active := range []bool{false, true} {
+			opts := DefaultOptions
+			dir, _ := os.MkdirTemp("", "bitcask-go-recovery-garbage")
+			opts.DirPath = dir
+			opts.DataFileSize = 64 * 1024
+			db, err := Open(opts)
+			assert.Nil(t, err)
+			for i := 0; i < 1000; i++ {
+				err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+				assert.Nil(t, err)
+			}
+			fileId := uint32(0)
+			if active {
+				fileId = db.activeFile.FileId
+			}
+			err = db.Close()
+			assert.Nil(t, err)
+
+			// 在数据文件的末尾追加无法解码的数据
+			file, err := os.OpenFile(data.GetDataFileName(dir, fileId), os.O_APPEND|os.O_WRONLY, 0644)
+			assert.Nil(t, err)
+			fileSize, _ := file.Seek(0, 2)
+			_, err = file.Write(garbage)
+			assert.Nil(t, err)
+			assert.Nil(t, file.Close())
+
+			opts.RecoveryPolicy = policy
+			db, err = Open(opts)
+			if policy == RecoveryFail || (policy == RecoveryTruncateTail && !active) {
+				assert.Equal(t, data.ErrInvalidCRC, err)
+				_ = os.RemoveAll(dir)
+				continue
+			}
+			assert.Nil(t, err)
+			report := db.RecoveryReport()
+			assert.Equal(t, 1, len(report.Discarded))
+			assert.Equal(t, fileId, report.Discarded[0].FileId)
+			assert.Equal(t, fileSize, report.Discarded[0].Offset)
+			assert.Equal(t, active, report.Discarded[0].Truncated)
+			assert.Equal(t, int64(len(garbage)), report.DiscardedBytes)
+			assert.Equal(t, 1000, len(db.ListKeys()))
+			destroyDB(db)
+		}
+	}
+}
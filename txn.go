@@ -0,0 +1,389 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Txn 乐观并发控制的事务
+// 读取的是事务开始时的快照数据，提交时如果读取过的 key 被其他提交修改过，则提交失败
+type Txn struct {
+	db            *DB
+	mu            *sync.Mutex
+	readOnly      bool
+	closed        bool
+	startSeqNo    uint64                     // 事务开始时的序列号
+	epoch         uint64                     // 事务开始时数据文件的版本
+	snapshot      map[txnKey]txnSnapshotPos  // 事务开始之后被其他提交修改过的 key 在快照中的位置
+	readSet       map[txnKey]struct{}        // 事务中读取过的 key
+	pendingWrites map[string]*data.LogRecord // 暂存事务中写入的数据
+}
+
+// key 在事务快照中的位置
+type txnSnapshotPos struct {
+	pos   *data.LogRecordPos // nil 表示不存在
+	epoch uint64             // 保存位置时数据文件的版本，数据文件之后被原地替换时依然读取保存时的文件
+}
+
+// 事务中的 key，不同 namespace 中相同的 key 互不影响
+type txnKey struct {
+	nsId uint32
+	key  string
+}
+
+// Begin 开启一个新的事务
+func (db *DB) Begin(readOnly bool) (*Txn, error) {
+	if !readOnly && db.options.ReadOnly {
+		return nil, ErrDatabaseIsReadOnly
+	}
+	if !readOnly && db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isInitial {
+		return nil, ErrSeqNoFileNotExists
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	txn := &Txn{
+		db:            db,
+		mu:            new(sync.Mutex),
+		readOnly:      readOnly,
+		startSeqNo:    db.seqNo,
+		snapshot:      make(map[txnKey]txnSnapshotPos),
+		readSet:       make(map[txnKey]struct{}),
+		pendingWrites: make(map[string]*data.LogRecord),
+		epoch:         db.epochs.acquire(),
+	}
+	db.activeTxns[txn] = struct{}{}
+	return txn, nil
+}
+
+// StartSeqNo 事务开始时的序列号
+func (txn *Txn) StartSeqNo() uint64 {
+	return txn.startSeqNo
+}
+
+// Get 读取事务快照中 key 对应的数据，事务中自己写入的数据可见
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	return txn.get(defaultNamespaceId, key)
+}
+
+// GetIn 读取事务快照中指定 namespace 的数据
+func (txn *Txn) GetIn(ns *Namespace, key []byte) ([]byte, error) {
+	return txn.get(ns.id, key)
+}
+
+// Put 在事务中写入数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	return txn.put(defaultNamespaceId, key, value)
+}
+
+// PutIn 在事务中写入数据到指定的 namespace，同一个事务中不同 namespace 的数据原子提交
+func (txn *Txn) PutIn(ns *Namespace, key []byte, value []byte) error {
+	return txn.put(ns.id, key, value)
+}
+
+// Delete 在事务中删除数据
+func (txn *Txn) Delete(key []byte) error {
+	return txn.delete(defaultNamespaceId, key)
+}
+
+// DeleteIn 在事务中删除指定 namespace 中的数据
+func (txn *Txn) DeleteIn(ns *Namespace, key []byte) error {
+	return txn.delete(ns.id, key)
+}
+
+func (txn *Txn) get(nsId uint32, key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return nil, ErrTxnClosed
+	}
+
+	// 优先读取事务中自己写入的数据
+	if record, ok := txn.pendingWrites[pendingWriteKey(nsId, key)]; ok {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	// 记录读集合，用于提交时的冲突检测
+	if !txn.readOnly {
+		txn.readSet[txnKey{nsId: nsId, key: string(key)}] = struct{}{}
+	}
+
+	txn.db.mu.RLock()
+	defer txn.db.mu.RUnlock()
+
+	snapshotPos := txn.snapshotPos(nsId, key)
+	if snapshotPos.pos == nil || snapshotPos.pos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return txn.db.getValueAt(snapshotPos.pos, snapshotPos.epoch, DefaultReadOptions)
+}
+
+func (txn *Txn) put(nsId uint32, key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if err := txn.checkWritable(); err != nil {
+		return err
+	}
+
+	txn.pendingWrites[pendingWriteKey(nsId, key)] = &data.LogRecord{Key: key, Value: value, Namespace: nsId}
+	return nil
+}
+
+func (txn *Txn) delete(nsId uint32, key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if err := txn.checkWritable(); err != nil {
+		return err
+	}
+
+	txn.pendingWrites[pendingWriteKey(nsId, key)] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted, Namespace: nsId}
+	return nil
+}
+
+// Commit 提交事务，如果读取过的 key 在事务开始之后被其他提交修改过，则返回 ErrTxnConflict
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	db := txn.db
+	defer db.metrics.observe(db.metrics.commits, db.metrics.commitLatency, time.Now())
+	// 压缩和加密不需要持有互斥锁，出错时依然需要在锁中结束事务
+	encoded, encodeErr := db.encodePendingWrites(txn.pendingWrites)
+	return db.commit(db.options.SyncWrites, func() error {
+		defer txn.close()
+
+		if encodeErr != nil {
+			return encodeErr
+		}
+		if len(txn.pendingWrites) == 0 {
+			return nil
+		}
+
+		// 冲突检测，快照中记录的 key 都是在事务开始之后被修改过的
+		for key := range txn.readSet {
+			if _, ok := txn.snapshot[key]; ok {
+				return ErrTxnConflict
+			}
+		}
+
+		// 删除不存在的 key 没有意义，不需要写入数据文件
+		for key, record := range txn.pendingWrites {
+			if record.Type == data.LogRecordDeleted && db.indexOf(record.Namespace).Get(record.Key) == nil {
+				delete(txn.pendingWrites, key)
+			}
+		}
+		if len(txn.pendingWrites) == 0 {
+			return nil
+		}
+
+		return db.commitPendingWrites(txn.pendingWrites, encoded, db.options.SyncWrites)
+	})
+}
+
+// Rollback 回滚事务，丢弃所有暂存的数据
+func (txn *Txn) Rollback() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return
+	}
+
+	txn.db.mu.Lock()
+	defer txn.db.mu.Unlock()
+	txn.close()
+}
+
+// 结束事务，释放快照
+// 在访问此方法前必须持有 db 的互斥锁
+func (txn *Txn) close() {
+	delete(txn.db.activeTxns, txn)
+	txn.db.epochs.release(txn.epoch)
+	txn.closed = true
+	txn.snapshot = nil
+	txn.readSet = nil
+	txn.pendingWrites = nil
+}
+
+func (txn *Txn) checkWritable() error {
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	if txn.readOnly {
+		return ErrTxnReadOnly
+	}
+	return nil
+}
+
+// 获取 key 在事务快照中的位置信息
+// 在访问此方法前必须持有 db 的读锁
+func (txn *Txn) snapshotPos(nsId uint32, key []byte) txnSnapshotPos {
+	if snapshotPos, ok := txn.snapshot[txnKey{nsId: nsId, key: string(key)}]; ok {
+		return snapshotPos
+	}
+	return txnSnapshotPos{pos: txn.db.indexOf(nsId).Get(key), epoch: latestEpoch}
+}
+
+// 在更新内存索引之前，为正在进行中的事务保存 key 的旧位置，保证事务读取到的是快照数据
+// 在访问此方法前必须持有互斥锁
+func (db *DB) saveTxnSnapshot(nsId uint32, key []byte) {
+	if len(db.activeTxns) == 0 {
+		return
+	}
+	snapshotKey := txnKey{nsId: nsId, key: string(key)}
+	oldPos := txnSnapshotPos{pos: db.indexOf(nsId).Get(key), epoch: db.epochs.latest()}
+	for txn := range db.activeTxns {
+		if _, ok := txn.snapshot[snapshotKey]; !ok {
+			txn.snapshot[snapshotKey] = oldPos
+		}
+	}
+}
+
+// TxnIterator 事务迭代器，遍历的是事务快照以及事务中自己写入的数据
+type TxnIterator struct {
+	txn     *Txn
+	items   []*txnIterItem
+	reverse bool
+	curr    int
+	epoch   uint64 // 创建时数据文件的版本
+	closed  bool
+}
+
+type txnIterItem struct {
+	key   []byte
+	pos   *data.LogRecordPos
+	epoch uint64 // 读取 pos 时使用的数据文件版本
+	value []byte
+}
+
+// Iterator 初始化事务迭代器
+// 迭代器使用完之后需要关闭，否则 merge 替换下来的数据文件无法删除
+func (txn *Txn) Iterator(opts IteratorOptions) (*TxnIterator, error) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return nil, ErrTxnClosed
+	}
+
+	txn.db.mu.RLock()
+	epoch := txn.db.epochs.acquire()
+	items := make(map[string]*txnIterItem)
+	indexIter := txn.db.index.Iterator(false)
+	for indexIter.Rewind(); indexIter.Valid(); indexIter.Next() {
+		key := indexIter.Key()
+		items[string(key)] = &txnIterItem{key: key, pos: indexIter.Value(), epoch: epoch}
+	}
+	indexIter.Close()
+	// 快照中的位置覆盖当前索引中的位置，迭代器只遍历默认的 namespace
+	for key, snapshotPos := range txn.snapshot {
+		if key.nsId != defaultNamespaceId {
+			continue
+		}
+		if snapshotPos.pos == nil {
+			delete(items, key.key)
+		} else {
+			items[key.key] = &txnIterItem{key: []byte(key.key), pos: snapshotPos.pos, epoch: snapshotPos.epoch}
+		}
+	}
+	txn.db.mu.RUnlock()
+
+	// 事务中自己写入的数据
+	for key, record := range txn.pendingWrites {
+		if record.Namespace != defaultNamespaceId {
+			continue
+		}
+		if record.Type == data.LogRecordDeleted {
+			delete(items, key)
+		} else {
+			items[key] = &txnIterItem{key: record.Key, value: record.Value}
+		}
+	}
+
+	now := time.Now().UnixNano()
+	values := make([]*txnIterItem, 0, len(items))
+	for _, item := range items {
+		if item.pos != nil && item.pos.IsExpired(now) {
+			continue
+		}
+		if len(opts.Prefix) > 0 && !bytes.HasPrefix(item.key, opts.Prefix) {
+			continue
+		}
+		values = append(values, item)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if opts.Reverse {
+			return bytes.Compare(values[i].key, values[j].key) > 0
+		}
+		return bytes.Compare(values[i].key, values[j].key) < 0
+	})
+
+	return &TxnIterator{txn: txn, items: values, reverse: opts.Reverse, epoch: epoch}, nil
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (it *TxnIterator) Rewind() {
+	it.curr = 0
+}
+
+// Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，根据从这个 key 开始遍历
+func (it *TxnIterator) Seek(key []byte) {
+	it.curr = sort.Search(len(it.items), func(i int) bool {
+		if it.reverse {
+			return bytes.Compare(it.items[i].key, key) <= 0
+		}
+		return bytes.Compare(it.items[i].key, key) >= 0
+	})
+}
+
+// Next 跳转到下一个 key
+func (it *TxnIterator) Next() {
+	it.curr += 1
+}
+
+// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+func (it *TxnIterator) Valid() bool {
+	return it.curr < len(it.items)
+}
+
+// Key 当前遍历位置的 Key 数据
+func (it *TxnIterator) Key() []byte {
+	return it.items[it.curr].key
+}
+
+// Value 当前遍历位置的 Value 数据
+func (it *TxnIterator) Value() ([]byte, error) {
+	item := it.items[it.curr]
+	if item.pos == nil {
+		return item.value, nil
+	}
+	it.txn.db.mu.RLock()
+	defer it.txn.db.mu.RUnlock()
+	return it.txn.db.getValueAt(item.pos, item.epoch, DefaultReadOptions)
+}
+
+// Close 关闭迭代器，释放相应资源
+func (it *TxnIterator) Close() {
+	it.items = nil
+	if !it.closed {
+		it.closed = true
+		it.txn.db.epochs.release(it.epoch)
+	}
+}
@@ -0,0 +1,155 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Txn_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	val1 := utils.RandomValue(10)
+	err = db.Put(utils.GetTestKey(1), val1)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	txn, err := db.Begin(true)
+	assert.Nil(t, err)
+
+	// 事务开始之后的修改对事务不可见
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	v, err := txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, v)
+	_, err = txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	iter, err := txn.Iterator(DefaultIteratorOptions)
+	assert.Nil(t, err)
+	var keys [][]byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+	iter.Close()
+	assert.Equal(t, [][]byte{utils.GetTestKey(1), utils.GetTestKey(2)}, keys)
+
+	// 只读事务不能写入
+	err = txn.Put(utils.GetTestKey(4), utils.RandomValue(10))
+	assert.Equal(t, ErrTxnReadOnly, err)
+	err = txn.Commit()
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrTxnClosed, err)
+}
+
+func TestDB_Txn_Commit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	// 1.正常提交，自己写入的数据在事务中可见
+	txn1, err := db.Begin(false)
+	assert.Nil(t, err)
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	val2 := utils.RandomValue(10)
+	err = txn1.Put(utils.GetTestKey(2), val2)
+	assert.Nil(t, err)
+	err = txn1.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	v, err := txn1.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, val2, v)
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = txn1.Commit()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	v, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, val2, v)
+
+	// 2.读取过的 key 被其他提交修改，提交失败
+	txn2, err := db.Begin(false)
+	assert.Nil(t, err)
+	_, err = txn2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(3), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	txn3, err := db.Begin(false)
+	assert.Nil(t, err)
+	err = txn3.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Nil(t, err)
+
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 3.没有读取过的 key 被修改，不会冲突
+	txn4, err := db.Begin(false)
+	assert.Nil(t, err)
+	err = txn4.Put(utils.GetTestKey(4), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = txn4.Commit()
+	assert.Nil(t, err)
+
+	// 4.回滚之后数据不会写入
+	txn5, err := db.Begin(false)
+	assert.Nil(t, err)
+	err = txn5.Put(utils.GetTestKey(5), utils.RandomValue(10))
+	assert.Nil(t, err)
+	txn5.Rollback()
+	_, err = db.Get(utils.GetTestKey(5))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, ErrTxnClosed, txn5.Commit())
+	assert.Equal(t, 0, len(db.activeTxns))
+
+	// 5.重启之后提交的数据依然存在
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -73,3 +74,48 @@ func CoypDir(src, dest string, exclude []string) error {
 
 	})
 }
+
+// 限速拷贝文件时每次读写的数据量
+const copyFileChunkSize = 256 * 1024
+
+// CopyFile 拷贝文件的前 size 个字节，每拷贝一块数据都会从限速器获取令牌，limiter 为 nil 时不限速
+func CopyFile(src, dest string, size int64, limiter *RateLimiter) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+	return CopyFrom(srcFile, dest, size, info.Mode(), limiter)
+}
+
+// CopyFrom 拷贝 src 的前 size 个字节到新的文件 dest 中，限速的方式和 CopyFile 相同
+func CopyFrom(src io.ReaderAt, dest string, size int64, perm os.FileMode, limiter *RateLimiter) error {
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	buf := make([]byte, copyFileChunkSize)
+	reader := io.NewSectionReader(src, 0, size)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			limiter.Wait(int64(n))
+			if _, err := destFile.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return destFile.Sync()
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// 单次等待的最长时间，等待期间调整了速率可以尽快生效
+const maxRateLimitWait = 100 * time.Millisecond
+
+// RateLimiter 令牌桶限速器，每秒产生 rate 个令牌，最多积累一秒的令牌
+// rate 小于等于 0 表示不限速，nil 的限速器同样不限速，可以在多个协程中并发使用
+type RateLimiter struct {
+	mu     *sync.Mutex
+	rate   int64
+	tokens float64   // 当前可用的令牌，小于 0 表示已经预支的令牌
+	last   time.Time // 上一次补充令牌的时间
+}
+
+// NewRateLimiter 创建每秒产生 rate 个令牌的限速器
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{
+		mu:   new(sync.Mutex),
+		rate: rate,
+		last: time.Now(),
+	}
+}
+
+// SetRate 调整每秒产生的令牌数量，正在等待的调用者按照新的速率继续等待
+func (l *RateLimiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = rate
+	if rate <= 0 {
+		l.tokens = 0
+	}
+}
+
+// Rate 返回每秒产生的令牌数量
+func (l *RateLimiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Wait 获取 n 个令牌，令牌不足时阻塞等待
+// n 可以大于一秒产生的令牌数量，不足的部分会预支，由后续的调用者等待补足
+func (l *RateLimiter) Wait(n int64) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	l.mu.Unlock()
+
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		if l.rate <= 0 || l.tokens >= 0 {
+			l.mu.Unlock()
+			return
+		}
+		wait := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		l.mu.Unlock()
+
+		if wait > maxRateLimitWait {
+			wait = maxRateLimitWait
+		}
+		time.Sleep(wait)
+	}
+}
+
+// 按照经过的时间补充令牌
+// 在访问此方法前必须持有互斥锁
+func (l *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last)
+	l.last = now
+	if l.rate <= 0 || elapsed <= 0 {
+		return
+	}
+	l.tokens += elapsed.Seconds() * float64(l.rate)
+	if burst := float64(l.rate); l.tokens > burst {
+		l.tokens = burst
+	}
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter := NewRateLimiter(10 * 1024)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		limiter.Wait(2 * 1024)
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 700*time.Millisecond, elapsed)
+	assert.True(t, elapsed < 2*time.Second, elapsed)
+
+	// 不限速时不会等待
+	var unlimited *RateLimiter
+	start = time.Now()
+	unlimited.Wait(1024 * 1024)
+	NewRateLimiter(0).Wait(1024 * 1024)
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+}
+
+func TestRateLimiter_SetRate(t *testing.T) {
+	limiter := NewRateLimiter(1024)
+	assert.Equal(t, int64(1024), limiter.Rate())
+
+	// 等待期间取消限速，等待者尽快返回
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		limiter.Wait(100 * 1024)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	limiter.SetRate(0)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("waiter is not released after rate limit is removed")
+	}
+	assert.Equal(t, int64(0), limiter.Rate())
+}
@@ -0,0 +1,360 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/flock"
+)
+
+// VerifyReport 离线校验数据目录的结果
+type VerifyReport struct {
+	DirPath     string
+	DataFiles   []*VerifyFileStat  // 数据文件的校验结果，按照文件 id 从小到大排列
+	IndexFiles  []*VerifyIndexStat // hint-index、选择性 merge 生成的 hint 文件以及 B+ 树索引的校验结果
+	IndexLoaded bool               // 是否成功加载了索引，加载失败时没有有效数据的统计
+	Problems    []VerifyProblem    // 发现的所有问题
+}
+
+// VerifyFileStat 单个数据文件的校验结果
+type VerifyFileStat struct {
+	FileId    uint32
+	Size      int64
+	Records   int   // 能够正常读取的记录数量
+	LiveBytes int64 // 索引中的有效数据占用的空间
+	DeadBytes int64 // 无效数据占用的空间，包括损坏的数据
+}
+
+// VerifyIndexStat 单个索引文件的校验结果
+type VerifyIndexStat struct {
+	Name    string
+	Entries int // 能够正常读取的索引条目数量
+	Invalid int // 没有指向有效数据的索引条目数量
+}
+
+// VerifyProblem 校验时发现的问题
+type VerifyProblem struct {
+	File   string // 问题所在的文件名称，为空表示与具体的文件无关
+	Offset int64  // 问题在文件中的位置，-1 表示与位置无关
+	Key    []byte // 有问题的索引条目对应的 key
+	Err    error
+}
+
+// OK 是否没有发现任何问题
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) addProblem(file string, offset int64, key []byte, err error) {
+	r.Problems = append(r.Problems, VerifyProblem{File: file, Offset: offset, Key: key, Err: err})
+}
+
+// Verify 离线校验数据目录，使用默认的配置项
+func Verify(dirPath string) (*VerifyReport, error) {
+	options := DefaultOptions
+	options.DirPath = dirPath
+	return VerifyWithOptions(options)
+}
+
+// VerifyWithOptions 离线校验数据目录，加密的数据需要配置 Options.Encryption
+// 校验所有数据文件的 CRC 和 header，检查 hint 文件和 B+ 树索引中的条目是否指向 key 相同的有效数据，
+// 并找出重复或者不会被使用的文件，数据库被其他进程写入时返回 ErrDatabaseIsUsing
+func VerifyWithOptions(options Options) (*VerifyReport, error) {
+	if _, err := os.Stat(options.DirPath); err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{DirPath: options.DirPath}
+
+	// 找出所有的数据文件和 hint 文件
+	dataFileNames := make(map[uint32][]string)
+	hintFileIds := make(map[uint32]struct{})
+	var hasHintIndex, hasBPTree bool
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, data.DataFileNameSuffix):
+			fileId, err := strconv.ParseUint(strings.TrimSuffix(name, data.DataFileNameSuffix), 10, 32)
+			if err != nil {
+				report.addProblem(name, -1, nil, ErrDataDirectoryCorrupted)
+				continue
+			}
+			dataFileNames[uint32(fileId)] = append(dataFileNames[uint32(fileId)], name)
+		case strings.HasSuffix(name, data.HintFileNameSuffix):
+			fileId, err := strconv.ParseUint(strings.TrimSuffix(name, data.HintFileNameSuffix), 10, 32)
+			if err != nil {
+				report.addProblem(name, -1, nil, ErrDataDirectoryCorrupted)
+				continue
+			}
+			hintFileIds[uint32(fileId)] = struct{}{}
+		case name == data.HintFileName:
+			hasHintIndex = true
+		case name == index.BPTreeIndexFileName:
+			hasBPTree = true
+		}
+	}
+
+	var fileIds []uint32
+	for fileId, names := range dataFileNames {
+		if len(names) > 1 {
+			for _, name := range names {
+				report.addProblem(name, -1, nil, ErrDuplicateFileId)
+			}
+		}
+		// 数据库只会按照固定的格式打开数据文件
+		canonical := filepath.Base(data.GetDataFileName(options.DirPath, fileId))
+		for _, name := range names {
+			if name != canonical {
+				report.addProblem(name, -1, nil, ErrOrphanedFile)
+			}
+		}
+		if _, err := os.Stat(data.GetDataFileName(options.DirPath, fileId)); err == nil {
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	for fileId := range hintFileIds {
+		if _, ok := dataFileNames[fileId]; !ok {
+			report.addProblem(filepath.Base(data.GetHintFileName(options.DirPath, fileId)), -1, nil, ErrOrphanedFile)
+		}
+	}
+
+	// 只读实例可以和读写实例同时打开，校验期间持有写锁的共享锁，保证没有其他进程写入
+	writerLock := flock.New(filepath.Join(options.DirPath, writerFileLockName))
+	hold, err := writerLock.TryRLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrDatabaseIsUsing
+	}
+	defer writerLock.Unlock()
+
+	// 以只读模式打开数据库，用于统计有效数据
+	dbOptions := options
+	dbOptions.ReadOnly = true
+	dbOptions.RecoveryPolicy = RecoverySkipCorrupt
+	dbOptions.EventListener = nil
+	if hasBPTree {
+		dbOptions.IndexType = BPlusTree
+	}
+	db, err := Open(dbOptions)
+	if err == ErrDatabaseIsUsing {
+		return nil, err
+	}
+	if err != nil {
+		report.addProblem("", -1, nil, err)
+	} else {
+		defer db.Close()
+		report.IndexLoaded = true
+	}
+
+	dataFiles := make(map[uint32]*data.DataFile, len(fileIds))
+	defer func() {
+		for _, dataFile := range dataFiles {
+			_ = dataFile.Close()
+		}
+	}()
+	for _, fileId := range fileIds {
+		dataFile, err := data.OpenDataFile(options.DirPath, fileId, fio.StandardFIO)
+		if err != nil {
+			return nil, err
+		}
+		dataFile.SetEncryptor(options.Encryption, options.EncryptKeys)
+		dataFiles[fileId] = dataFile
+
+		stat, err := verifyDataFile(report, dataFile)
+		if err != nil {
+			return nil, err
+		}
+		report.DataFiles = append(report.DataFiles, stat)
+	}
+
+	// 校验 hint 文件中的索引条目，选择性 merge 重写过的数据文件以其独立的 hint 文件为准
+	if hasHintIndex {
+		stat, err := verifyHintFile(report, options, data.HintFileName, func() (*data.DataFile, error) {
+			return data.OpenHintFile(options.DirPath)
+		}, dataFiles, hintFileIds)
+		if err != nil {
+			return nil, err
+		}
+		report.IndexFiles = append(report.IndexFiles, stat)
+	}
+	for _, fileId := range fileIds {
+		if _, ok := hintFileIds[fileId]; !ok {
+			continue
+		}
+		name := filepath.Base(data.GetHintFileName(options.DirPath, fileId))
+		stat, err := verifyHintFile(report, options, name, func() (*data.DataFile, error) {
+			return data.OpenFileHintFile(options.DirPath, fileId)
+		}, dataFiles, nil)
+		if err != nil {
+			return nil, err
+		}
+		report.IndexFiles = append(report.IndexFiles, stat)
+	}
+
+	if db == nil {
+		return report, nil
+	}
+
+	// 统计索引中的有效数据，B+ 树索引中的条目同时需要校验
+	liveBytes := make(map[uint32]int64)
+	indexes := map[uint32]index.Indexer{defaultNamespaceId: db.index}
+	for nsId, ns := range db.namespaces {
+		indexes[nsId] = ns.index
+	}
+	var bptreeStat *VerifyIndexStat
+	if hasBPTree {
+		bptreeStat = &VerifyIndexStat{Name: index.BPTreeIndexFileName}
+	}
+	for nsId, idx := range indexes {
+		iterator := idx.Iterator(false)
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			pos := iterator.Value()
+			typ := data.LogRecordNormal
+			node, isOperand := db.operands[operandPosOf(pos)]
+			if isOperand {
+				typ = data.LogRecordMergeOperand
+			}
+			size, err := verifyIndexEntry(dataFiles, nsId, iterator.Key(), typ, pos)
+			if bptreeStat != nil {
+				bptreeStat.Entries++
+				if err != nil {
+					bptreeStat.Invalid++
+					report.addProblem(index.BPTreeIndexFileName, -1, iterator.Key(), err)
+				}
+			}
+			if err == nil {
+				liveBytes[pos.Fid] += size
+			}
+			// 合并操作数之前的记录同样是有效数据
+			for isOperand && node.prev != nil {
+				liveBytes[node.prev.Fid] += int64(node.prev.Size)
+				node, isOperand = db.operands[operandPosOf(node.prev)]
+			}
+		}
+		iterator.Close()
+	}
+	if bptreeStat != nil {
+		report.IndexFiles = append(report.IndexFiles, bptreeStat)
+	}
+	for _, stat := range report.DataFiles {
+		stat.LiveBytes = liveBytes[stat.FileId]
+		stat.DeadBytes = stat.Size - stat.LiveBytes
+	}
+	return report, nil
+}
+
+// 校验数据文件中的所有记录，损坏的数据会被跳过
+func verifyDataFile(report *VerifyReport, dataFile *data.DataFile) (*VerifyFileStat, error) {
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	stat := &VerifyFileStat{FileId: dataFile.FileId, Size: size}
+	name := filepath.Base(data.GetDataFileName("", dataFile.FileId))
+	err = scanLogRecords(report, name, dataFile, func(*data.LogRecord, int64) {
+		stat.Records++
+	})
+	return stat, err
+}
+
+// 校验 hint 文件中的索引条目是否指向 key 相同的有效数据，skipFileIds 中的数据文件不需要校验
+func verifyHintFile(report *VerifyReport, options Options, name string, openHintFile func() (*data.DataFile, error),
+	dataFiles map[uint32]*data.DataFile, skipFileIds map[uint32]struct{}) (*VerifyIndexStat, error) {
+	hintFile, err := openHintFile()
+	if err != nil {
+		return nil, err
+	}
+	defer hintFile.Close()
+	hintFile.SetEncryptor(options.Encryption, options.EncryptKeys)
+
+	stat := &VerifyIndexStat{Name: name}
+	// 写入数据时生成的 hint 文件中的 key 带有事务序列号
+	var sealed bool
+	err = scanLogRecords(report, name, hintFile, func(logRecord *data.LogRecord, offset int64) {
+		if isFileHintMarker(logRecord) {
+			sealed = true
+			return
+		}
+		stat.Entries++
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if _, ok := skipFileIds[pos.Fid]; ok {
+			return
+		}
+		key := logRecord.Key
+		if sealed {
+			key, _ = parseLogRecordKey(key)
+		}
+		if _, err := verifyIndexEntry(dataFiles, logRecord.Namespace, key, logRecord.Type, pos); err != nil {
+			stat.Invalid++
+			report.addProblem(name, offset, logRecord.Key, err)
+		}
+	})
+	return stat, err
+}
+
+// 依次读取文件中的记录，损坏的数据记录到校验结果中并跳过
+// 无法解码的数据导致的 panic 同样记录到校验结果中，不再继续读取这个文件
+func scanLogRecords(report *VerifyReport, name string, dataFile *data.DataFile, fn func(*data.LogRecord, int64)) (err error) {
+	var offset int64 = 0
+	defer func() {
+		if r := recover(); r != nil {
+			report.addProblem(name, offset, nil, fmt.Errorf("%w: %v", ErrDecodePanicked, r))
+			err = nil
+		}
+	}()
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			report.addProblem(name, offset, nil, err)
+			next, err := dataFile.NextLogRecordOffset(offset)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			offset = next
+			continue
+		}
+		fn(logRecord, offset)
+		offset += size
+	}
+}
+
+// 校验索引条目是否指向 key 和类型都相同的有效数据，返回数据的大小
+func verifyIndexEntry(dataFiles map[uint32]*data.DataFile, nsId uint32, key []byte,
+	typ data.LogRecordType, pos *data.LogRecordPos) (int64, error) {
+	dataFile := dataFiles[pos.Fid]
+	if dataFile == nil {
+		return 0, ErrIndexEntryInvalid
+	}
+	logRecord, size, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil || (pos.Size != 0 && int64(pos.Size) != size) {
+		return 0, ErrIndexEntryInvalid
+	}
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+	if logRecord.Namespace != nsId || logRecord.Type != typ || !bytes.Equal(realKey, key) {
+		return 0, ErrIndexKeyMismatch
+	}
+	return size, nil
+}
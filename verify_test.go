@@ -0,0 +1,160 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 200; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	for i := 1000; i < 1100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	// 数据库正在使用时不能校验
+	_, err = Verify(dir)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.True(t, report.IndexLoaded)
+	assert.True(t, len(report.DataFiles) > 1)
+	var records int
+	var liveBytes int64
+	for _, stat := range report.DataFiles {
+		records += stat.Records
+		liveBytes += stat.LiveBytes
+		assert.Equal(t, stat.Size, stat.LiveBytes+stat.DeadBytes)
+	}
+	assert.True(t, records >= 900)
+	assert.True(t, liveBytes > 0)
+	assert.Equal(t, 2, len(report.IndexFiles))
+	assert.Equal(t, data.HintFileName, report.IndexFiles[0].Name)
+	assert.Equal(t, 800, report.IndexFiles[0].Entries)
+	// 关闭时活跃文件生成的 hint 文件
+	assert.Equal(t, 100, report.IndexFiles[1].Entries)
+	assert.Equal(t, 0, report.IndexFiles[1].Invalid)
+
+	// 破坏数据文件，并放入不会被使用的文件
+	// merge 之后最小的数据文件由 hint-index 加载索引
+	fileId := report.DataFiles[0].FileId
+	file, err := os.OpenFile(data.GetDataFileName(dir, fileId), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted"), 1024)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	err = os.WriteFile(filepath.Join(dir, strconv.Itoa(int(fileId))+data.DataFileNameSuffix), nil, 0644)
+	assert.Nil(t, err)
+	err = os.WriteFile(data.GetHintFileName(dir, 999), nil, 0644)
+	assert.Nil(t, err)
+
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	problems := make(map[error]int)
+	for _, p := range report.Problems {
+		problems[p.Err]++
+	}
+	assert.Equal(t, 1, problems[data.ErrInvalidCRC])
+	assert.Equal(t, 2, problems[ErrDuplicateFileId])
+	assert.Equal(t, 2, problems[ErrOrphanedFile])
+	// hint 文件中指向被破坏数据的条目
+	assert.True(t, problems[ErrIndexEntryInvalid] >= 1)
+	assert.True(t, report.IndexFiles[0].Invalid >= 1)
+}
+
+func TestVerify_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 1, len(report.IndexFiles))
+	assert.Equal(t, 100, report.IndexFiles[0].Entries)
+	assert.Equal(t, report.DataFiles[0].Size, report.DataFiles[0].LiveBytes)
+}
+
+func TestVerify_GarbageTail(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify-garbage")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 在数据文件的末尾追加无法解码的数据
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	fileSize, _ := file.Seek(0, 2)
+	_, err = file.Write(bytes.Repeat([]byte{0xff}, 64))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.True(t, report.IndexLoaded)
+	assert.Equal(t, 1, len(report.Problems))
+	assert.Equal(t, filepath.Base(data.GetDataFileName(dir, 0)), report.Problems[0].File)
+	assert.Equal(t, fileSize, report.Problems[0].Offset)
+	assert.Equal(t, data.ErrInvalidCRC, report.Problems[0].Err)
+	assert.Equal(t, 100, report.DataFiles[0].Records)
+
+	opts.RecoveryPolicy = RecoveryTruncateTail
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,218 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+type WatchEventType = byte
+
+const (
+	// WatchEventPut 写入数据
+	WatchEventPut WatchEventType = iota + 1
+
+	// WatchEventDelete 删除数据
+	WatchEventDelete
+)
+
+// WatchEvent 数据变更事件
+type WatchEvent struct {
+	Type  WatchEventType
+	Key   []byte
+	Value []byte
+	SeqNo uint64 // 事务序列号，非事务写入为 0
+}
+
+// WatchBatch 一次提交产生的变更事件，WriteBatch 提交的数据会作为一个整体投递
+type WatchBatch struct {
+	Events  []*WatchEvent
+	Dropped uint64 // 在此之前因为消费过慢被丢弃的批次数量，只在 WatchDropOnFull 策略下使用
+}
+
+// 订阅者
+type watcher struct {
+	ctx     context.Context
+	prefix  []byte
+	mu      *sync.Mutex // 投递和关闭 channel 互斥，投递时不持有 watchState 的锁
+	ch      chan *WatchBatch
+	dropped uint64
+	closed  bool
+}
+
+// 事件投递相关的状态
+type watchState struct {
+	mu            *sync.Mutex
+	cond          *sync.Cond
+	watchers      map[*watcher]struct{}
+	watcherNum    int32         // 订阅者数量，没有订阅者时不需要生成事件
+	pendingEvents []*WatchEvent // 持有互斥锁期间产生的事件，释放锁之后投递
+	nextTicket    uint64        // 下一个待分配的投递序号
+	deliverTicket uint64        // 当前可以投递的序号，保证事件按照提交的顺序投递
+	closeCh       chan struct{} // 数据库关闭时关闭，结束订阅者的协程以及阻塞的投递
+}
+
+func newWatchState() *watchState {
+	mu := new(sync.Mutex)
+	return &watchState{
+		mu:       mu,
+		cond:     sync.NewCond(mu),
+		watchers: make(map[*watcher]struct{}),
+		closeCh:  make(chan struct{}),
+	}
+}
+
+// Watch 订阅前缀为 prefix 的 key 的变更事件，ctx 结束或者数据库关闭时 channel 会被关闭
+// 消费过慢时的处理策略由 Options.WatchPolicy 决定
+func (db *DB) Watch(ctx context.Context, prefix []byte) (<-chan *WatchBatch, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	w := &watcher{
+		ctx:    ctx,
+		prefix: prefix,
+		mu:     new(sync.Mutex),
+		ch:     make(chan *WatchBatch, db.options.WatchBufferSize),
+	}
+	ws := db.watch
+	ws.mu.Lock()
+	ws.watchers[w] = struct{}{}
+	atomic.AddInt32(&ws.watcherNum, 1)
+	ws.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-ws.closeCh:
+		}
+		ws.removeWatcher(w)
+	}()
+	return w.ch, nil
+}
+
+// 记录变更事件，没有订阅者时直接忽略
+// 事件在写入返回之后才会投递，调用方可能会复用 key 和 value 的内存，因此需要拷贝
+// 在访问此方法前必须持有互斥锁
+func (db *DB) addWatchEvent(typ WatchEventType, key, value []byte, seqNo uint64) {
+	if atomic.LoadInt32(&db.watch.watcherNum) == 0 {
+		return
+	}
+	event := &WatchEvent{
+		Type:  typ,
+		Key:   append([]byte(nil), key...),
+		SeqNo: seqNo,
+	}
+	if value != nil {
+		event.Value = append([]byte(nil), value...)
+	}
+	db.watch.pendingEvents = append(db.watch.pendingEvents, event)
+}
+
+// 释放互斥锁，并将持有锁期间产生的事件投递给订阅者
+// 投递时不持有互斥锁，阻塞的订阅者不会影响读操作
+func (db *DB) unlockAndNotify() {
+	ws := db.watch
+	events := ws.pendingEvents
+	if len(events) == 0 {
+		db.mu.Unlock()
+		return
+	}
+	ws.pendingEvents = nil
+	ticket := ws.nextTicket
+	ws.nextTicket++
+	db.mu.Unlock()
+
+	ws.mu.Lock()
+	// 按照提交的顺序依次投递
+	for ws.deliverTicket != ticket {
+		ws.cond.Wait()
+	}
+	watchers := make([]*watcher, 0, len(ws.watchers))
+	for w := range ws.watchers {
+		watchers = append(watchers, w)
+	}
+	ws.mu.Unlock()
+
+	// 投递时不持有 ws.mu，阻塞的订阅者不会影响其他订阅者的取消以及数据库的关闭
+	for _, w := range watchers {
+		ws.deliver(w, events, db.options.WatchPolicy)
+	}
+
+	ws.mu.Lock()
+	ws.deliverTicket++
+	ws.cond.Broadcast()
+	ws.mu.Unlock()
+}
+
+// 将事件投递给订阅者，已经关闭的订阅者直接忽略
+func (ws *watchState) deliver(w *watcher, events []*WatchEvent, policy WatchPolicy) {
+	var matched []*WatchEvent
+	for _, event := range events {
+		if bytes.HasPrefix(event.Key, w.prefix) {
+			matched = append(matched, event)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	batch := &WatchBatch{Events: matched, Dropped: w.dropped}
+	if policy == WatchBlockOnFull {
+		select {
+		case w.ch <- batch:
+		case <-w.ctx.Done():
+		case <-ws.closeCh:
+		}
+		return
+	}
+
+	select {
+	case w.ch <- batch:
+		w.dropped = 0
+	default:
+		w.dropped++
+	}
+}
+
+// 移除订阅者，等待正在进行的投递结束之后关闭 channel
+func (ws *watchState) removeWatcher(w *watcher) {
+	ws.mu.Lock()
+	if _, ok := ws.watchers[w]; ok {
+		delete(ws.watchers, w)
+		atomic.AddInt32(&ws.watcherNum, -1)
+	}
+	ws.mu.Unlock()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+}
+
+// 关闭所有的订阅者，阻塞的投递会直接结束
+func (ws *watchState) close() {
+	ws.mu.Lock()
+	select {
+	case <-ws.closeCh:
+	default:
+		close(ws.closeCh)
+	}
+	watchers := make([]*watcher, 0, len(ws.watchers))
+	for w := range ws.watchers {
+		watchers = append(watchers, w)
+	}
+	ws.mu.Unlock()
+
+	for _, w := range watchers {
+		ws.removeWatcher(w)
+	}
+}
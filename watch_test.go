@@ -0,0 +1,203 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := db.Watch(ctx, []byte("user-"))
+	assert.Nil(t, err)
+
+	// 1.Put 和 Delete 分别产生一个事件，前缀不匹配的不会投递
+	err = db.Put([]byte("user-1"), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("order-1"), []byte("b"))
+	assert.Nil(t, err)
+	err = db.Delete([]byte("user-1"))
+	assert.Nil(t, err)
+
+	batch := <-ch
+	assert.Equal(t, 1, len(batch.Events))
+	assert.Equal(t, WatchEventPut, batch.Events[0].Type)
+	assert.Equal(t, []byte("user-1"), batch.Events[0].Key)
+	assert.Equal(t, []byte("a"), batch.Events[0].Value)
+	batch = <-ch
+	assert.Equal(t, 1, len(batch.Events))
+	assert.Equal(t, WatchEventDelete, batch.Events[0].Type)
+
+	// 2.WriteBatch 提交的数据作为一个整体投递
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put([]byte("user-2"), []byte("c"))
+	_ = wb.Put([]byte("user-3"), []byte("d"))
+	_ = wb.Put([]byte("order-2"), []byte("e"))
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	batch = <-ch
+	assert.Equal(t, 2, len(batch.Events))
+	for _, event := range batch.Events {
+		assert.Equal(t, WatchEventPut, event.Type)
+		assert.Equal(t, db.seqNo, event.SeqNo)
+	}
+
+	// 3.写入返回之后复用 key 和 value 的内存，不影响还没有被读取的事件
+	key, value := []byte("user-4"), []byte("f")
+	err = db.Put(key, value)
+	assert.Nil(t, err)
+	copy(key, "user-5")
+	copy(value, "g")
+	batch = <-ch
+	assert.Equal(t, []byte("user-4"), batch.Events[0].Key)
+	assert.Equal(t, []byte("f"), batch.Events[0].Value)
+
+	// 4.取消订阅之后 channel 被关闭
+	cancel()
+	for range ch {
+	}
+}
+
+func TestDB_Watch_DropOnFull(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-2")
+	opts.DirPath = dir
+	opts.WatchBufferSize = 2
+	opts.WatchPolicy = WatchDropOnFull
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ch, err := db.Watch(context.Background(), nil)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	<-ch
+	<-ch
+	err = db.Put(utils.GetTestKey(10), utils.RandomValue(10))
+	assert.Nil(t, err)
+	batch := <-ch
+	assert.Equal(t, utils.GetTestKey(10), batch.Events[0].Key)
+	assert.Equal(t, uint64(8), batch.Dropped)
+
+	// 数据库关闭之后 channel 被关闭
+	err = db.Close()
+	assert.Nil(t, err)
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestDB_Watch_BlockOnFull(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-3")
+	opts.DirPath = dir
+	opts.WatchBufferSize = 1
+	opts.WatchPolicy = WatchBlockOnFull
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := db.Watch(ctx, nil)
+	assert.Nil(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+			assert.Nil(t, err)
+		}
+	}()
+
+	// 写入被阻塞时依然可以读取数据，事件按照写入的顺序投递
+	for i := 0; i < 100; i++ {
+		batch := <-ch
+		assert.Equal(t, utils.GetTestKey(i), batch.Events[0].Key)
+		val, err := db.Get(batch.Events[0].Key)
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("writer is blocked")
+	}
+}
+
+func TestDB_Watch_CloseStalled(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-4")
+	opts.DirPath = dir
+	opts.WatchBufferSize = 1
+	opts.WatchPolicy = WatchBlockOnFull
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 从不取消的 context，也从不消费事件
+	stalled, err := db.Watch(context.Background(), nil)
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	other, err := db.Watch(ctx, []byte("other-"))
+	assert.Nil(t, err)
+
+	// 第一个事件占满缓冲区，第二次写入阻塞在投递上
+	err = db.Put(utils.GetTestKey(0), utils.RandomValue(10))
+	assert.Nil(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	// 1.阻塞的订阅者不影响其他订阅者的取消
+	cancel()
+	select {
+	case _, ok := <-other:
+		assert.False(t, ok)
+	case <-time.After(time.Second * 5):
+		t.Fatal("watcher is not closed after cancel")
+	}
+
+	// 2.阻塞的订阅者不影响数据库的关闭，关闭之后 channel 也会被关闭
+	closed := make(chan error)
+	go func() {
+		closed <- db.Close()
+	}()
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("close is blocked by the stalled watcher")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("writer is blocked")
+	}
+	for range stalled {
+	}
+}